@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"os"
@@ -14,13 +15,19 @@ import (
 
 	"github.com/gustycube/spyder/internal/config"
 	"github.com/gustycube/spyder/internal/dedup"
+	"github.com/gustycube/spyder/internal/dns"
+	"github.com/gustycube/spyder/internal/egress"
 	"github.com/gustycube/spyder/internal/emit"
+	"github.com/gustycube/spyder/internal/enrich"
+	"github.com/gustycube/spyder/internal/enum"
 	"github.com/gustycube/spyder/internal/health"
 	"github.com/gustycube/spyder/internal/logging"
 	"github.com/gustycube/spyder/internal/metrics"
 	"github.com/gustycube/spyder/internal/probe"
 	"github.com/gustycube/spyder/internal/queue"
+	"github.com/gustycube/spyder/internal/takeover"
 	"github.com/gustycube/spyder/internal/telemetry"
+	"github.com/gustycube/spyder/internal/tlsinfo"
 )
 
 func main() {
@@ -35,6 +42,31 @@ func main() {
 	var metricsAddr string
 	var batchMax int
 	var batchFlushSec int
+	var maxPages int
+	var rootCandidates string
+	var dnsServers string
+	var suppressWildcard bool
+	var zoneRecords bool
+	var axfr bool
+	var delegationCheck bool
+	var dnsCache bool
+	var mmdbFiles string
+	var providers bool
+	var providerRanges, providerSuffixes string
+	var rdapBootstrap string
+	var rdapQPS float64
+	var dnsCacheMinTTL, dnsCacheMaxTTL, dnsCacheNegTTL int
+	var proxyURL, sourceIP string
+	var fetchEachIP bool
+	var tlsFingerprint bool
+	var mxCerts bool
+	var defaultCerts bool
+	var enumSubs, enumPermute bool
+	var enumWordlist string
+	var enumQPS float64
+	var takeoverCheck bool
+	var takeoverSigs string
+	var tlsRoots string
 	var spoolDir string
 	var otelEndpoint string
 	var otelInsecure bool
@@ -58,6 +90,36 @@ func main() {
 	flag.StringVar(&metricsAddr, "metrics_addr", "", "metrics listen addr (empty to disable)")
 	flag.IntVar(&batchMax, "batch_max_edges", 0, "max edges per batch before flush")
 	flag.IntVar(&batchFlushSec, "batch_flush_sec", 0, "seconds timer to flush a batch")
+	flag.IntVar(&maxPages, "max_pages", 0, "per-host page budget for the same-apex crawl")
+	flag.StringVar(&dnsServers, "dns_servers", "", "comma-separated DNS servers (host[:port], tcp://, tls:// for DoT or https:// DoH URLs) to query instead of /etc/resolv.conf")
+	flag.BoolVar(&zoneRecords, "zone_records", false, "collect SOA, CAA, SRV and DNSSEC status per apex and PTR names per address")
+	flag.BoolVar(&axfr, "axfr", false, "attempt a zone transfer from each apex's name servers and import allowed zones")
+	flag.BoolVar(&delegationCheck, "delegation_check", false, "query parent and authoritative name servers directly and flag lame or inconsistent delegations")
+	flag.StringVar(&mmdbFiles, "mmdb", "", "comma-separated MaxMind/IPinfo MMDB files for offline ASN, prefix and country enrichment")
+	flag.BoolVar(&providers, "providers", false, "attribute hosts and IPs to cloud/CDN providers and emit HOSTED_ON and DNS_HOSTED_ON edges")
+	flag.StringVar(&providerRanges, "provider_ranges", "", "comma-separated provider=path published IP range files (AWS/GCP/Azure JSON or one CIDR per line)")
+	flag.StringVar(&providerSuffixes, "provider_suffixes", "", "YAML/JSON provider suffix pattern file (default: built-in set)")
+	flag.StringVar(&rdapBootstrap, "rdap_bootstrap", "", "IANA RDAP bootstrap file (dns.json); enables registration lookups for each apex")
+	flag.Float64Var(&rdapQPS, "rdap_qps", 0, "RDAP requests per second per registry (default 1)")
+	flag.BoolVar(&dnsCache, "dns_cache", false, "cache recursive DNS answers for their TTL, shared by all workers")
+	flag.IntVar(&dnsCacheMinTTL, "dns_cache_min_ttl_sec", 0, "lower clamp on cached TTLs (default 5)")
+	flag.IntVar(&dnsCacheMaxTTL, "dns_cache_max_ttl_sec", 0, "upper clamp on cached TTLs (default 3600)")
+	flag.IntVar(&dnsCacheNegTTL, "dns_cache_negative_ttl_sec", 0, "upper clamp on cached NXDOMAIN/NODATA answers (default 300)")
+	flag.BoolVar(&suppressWildcard, "suppress_wildcard", false, "drop RESOLVES_TO edges that only match a zone's wildcard answer instead of marking them")
+	flag.StringVar(&rootCandidates, "root_candidates", "", "comma-separated scheme:port candidates for the root fetch (e.g. https:443,http:80,https:8443)")
+	flag.StringVar(&proxyURL, "proxy", "", "egress proxy for probe traffic (http://host:port or socks5://host:port, optional user:pass@)")
+	flag.StringVar(&sourceIP, "source_ip", "", "local source IP to bind probe connections to")
+	flag.BoolVar(&fetchEachIP, "fetch_each_ip", false, "also fetch the root page and certificate from every resolved IP")
+	flag.BoolVar(&tlsFingerprint, "tls_fingerprint", false, "compute a JARM-style TLS fingerprint per host (extra handshakes)")
+	flag.BoolVar(&mxCerts, "mx_certs", false, "collect STARTTLS certificates from MX hosts")
+	flag.BoolVar(&defaultCerts, "default_certs", false, "handshake with each resolved IP without SNI to record default certificates")
+	flag.BoolVar(&enumSubs, "enum", false, "brute-force subdomains of each apex from a wordlist and crawl them")
+	flag.StringVar(&enumWordlist, "enum_wordlist", "", "wordlist for -enum, one label per line (default: built-in list)")
+	flag.BoolVar(&enumPermute, "enum_permute", false, "also try affixed permutations of each word (dev-, -staging, 1, ...)")
+	flag.Float64Var(&enumQPS, "enum_qps", 0, "DNS queries per second for enumeration (default 50)")
+	flag.BoolVar(&takeoverCheck, "takeover_check", false, "flag dangling CNAMEs and subdomain-takeover candidates")
+	flag.StringVar(&takeoverSigs, "takeover_signatures", "", "YAML/JSON takeover signature file (default: built-in set)")
+	flag.StringVar(&tlsRoots, "tls_roots", "", "PEM bundle to verify probed certificates against (default: system roots)")
 	flag.StringVar(&spoolDir, "spool_dir", "", "spool dir for failed batches")
 	flag.StringVar(&mtlsCert, "mtls_cert", "", "client cert (PEM) for mTLS to ingest")
 	flag.StringVar(&mtlsKey, "mtls_key", "", "client key (PEM) for mTLS to ingest")
@@ -158,6 +220,120 @@ func main() {
 	if batchFlushSec > 0 {
 		flags["batch_flush_sec"] = batchFlushSec
 	}
+	if maxPages > 0 {
+		flags["max_pages"] = maxPages
+	}
+	if rootCandidates != "" {
+		var cands []string
+		for _, c := range strings.Split(rootCandidates, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				cands = append(cands, c)
+			}
+		}
+		flags["root_candidates"] = cands
+	}
+	if dnsServers != "" {
+		var servers []string
+		for _, s := range strings.Split(dnsServers, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				servers = append(servers, s)
+			}
+		}
+		flags["dns_servers"] = servers
+	}
+	if fetchEachIP {
+		flags["fetch_each_ip"] = true
+	}
+	if tlsFingerprint {
+		flags["tls_fingerprint"] = true
+	}
+	if mxCerts {
+		flags["mx_certs"] = true
+	}
+	if defaultCerts {
+		flags["default_certs"] = true
+	}
+	if tlsRoots != "" {
+		flags["tls_roots"] = tlsRoots
+	}
+	if zoneRecords {
+		flags["zone_records"] = true
+	}
+	if axfr {
+		flags["axfr"] = true
+	}
+	if delegationCheck {
+		flags["delegation_check"] = true
+	}
+	if mmdbFiles != "" {
+		var files []string
+		for _, s := range strings.Split(mmdbFiles, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				files = append(files, s)
+			}
+		}
+		flags["mmdb"] = files
+	}
+	if providers {
+		flags["providers"] = true
+	}
+	if providerRanges != "" {
+		var files []string
+		for _, s := range strings.Split(providerRanges, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				files = append(files, s)
+			}
+		}
+		flags["provider_ranges"] = files
+	}
+	if providerSuffixes != "" {
+		flags["provider_suffixes"] = providerSuffixes
+	}
+	if rdapBootstrap != "" {
+		flags["rdap_bootstrap"] = rdapBootstrap
+	}
+	if rdapQPS > 0 {
+		flags["rdap_qps"] = rdapQPS
+	}
+	if dnsCache {
+		flags["dns_cache"] = true
+	}
+	if dnsCacheMinTTL > 0 {
+		flags["dns_cache_min_ttl_sec"] = dnsCacheMinTTL
+	}
+	if dnsCacheMaxTTL > 0 {
+		flags["dns_cache_max_ttl_sec"] = dnsCacheMaxTTL
+	}
+	if dnsCacheNegTTL > 0 {
+		flags["dns_cache_negative_ttl_sec"] = dnsCacheNegTTL
+	}
+	if suppressWildcard {
+		flags["suppress_wildcard"] = true
+	}
+	if enumSubs {
+		flags["enum"] = true
+	}
+	if enumWordlist != "" {
+		flags["enum_wordlist"] = enumWordlist
+	}
+	if enumPermute {
+		flags["enum_permute"] = true
+	}
+	if enumQPS > 0 {
+		flags["enum_qps"] = enumQPS
+	}
+	if takeoverCheck {
+		flags["takeover_check"] = true
+	}
+	if takeoverSigs != "" {
+		flags["takeover_signatures"] = takeoverSigs
+	}
+	if proxyURL != "" {
+		flags["proxy"] = proxyURL
+	}
+	if sourceIP != "" {
+		flags["source_ip"] = sourceIP
+	}
 	if spoolDir != "" {
 		flags["spool_dir"] = spoolDir
 	}
@@ -326,7 +502,101 @@ func main() {
 	log.Info("service marked as ready")
 
 	// Start probe
-	p := probe.New(cfg.UA, cfg.Probe, cfg.Run, cfg.ExcludeTLDs, d, batches, log)
+	// All probe traffic leaves through the configured egress
+	egressDialer, err := egress.New(egress.Config{Proxy: cfg.Proxy, SourceIP: cfg.SourceIP})
+	if err != nil {
+		log.Fatal("egress init", "err", err)
+	}
+	if cfg.Proxy != "" || cfg.SourceIP != "" {
+		log.Info("egress configured", "proxy_set", cfg.Proxy != "", "source_ip", cfg.SourceIP)
+	}
+
+	var roots *x509.CertPool
+	if cfg.TLSRoots != "" {
+		if roots, err = tlsinfo.LoadRoots(cfg.TLSRoots); err != nil {
+			log.Fatal("load tls roots", "err", err)
+		}
+	}
+
+	var takeoverSignatures []takeover.Signature
+	if cfg.TakeoverSignatures != "" {
+		if takeoverSignatures, err = takeover.LoadSignatures(cfg.TakeoverSignatures); err != nil {
+			log.Fatal("load takeover signatures", "err", err)
+		}
+	}
+
+	resolver := dns.NewResolver(cfg.DNSServers)
+	if cfg.DNSCache {
+		sec := func(n int) time.Duration { return time.Duration(n) * time.Second }
+		resolver.Cache = dns.NewCache(sec(cfg.DNSCacheMinTTL), sec(cfg.DNSCacheMaxTTL), sec(cfg.DNSCacheNegTTL))
+	}
+
+	var mmdb *enrich.MMDB
+	if len(cfg.MMDB) > 0 {
+		if mmdb, err = enrich.OpenMMDB(cfg.MMDB...); err != nil {
+			log.Fatal("open mmdb", "err", err)
+		}
+		defer mmdb.Close()
+	}
+
+	var classifier *enrich.Classifier
+	if cfg.Providers {
+		var suffixes []enrich.Suffix
+		if cfg.ProviderSuffixes != "" {
+			if suffixes, err = enrich.LoadSuffixes(cfg.ProviderSuffixes); err != nil {
+				log.Fatal("load provider suffixes", "err", err)
+			}
+		}
+		classifier = enrich.NewClassifier(suffixes)
+		for _, spec := range cfg.ProviderRanges {
+			// Validate has checked the provider=path form
+			name, file, _ := strings.Cut(spec, "=")
+			if err := classifier.LoadRanges(name, file); err != nil {
+				log.Fatal("load provider ranges", "err", err)
+			}
+		}
+	}
+
+	var rdap enrich.Bootstrap
+	if cfg.RDAPBootstrap != "" {
+		if rdap, err = enrich.LoadBootstrap(cfg.RDAPBootstrap); err != nil {
+			log.Fatal("load rdap bootstrap", "err", err)
+		}
+	}
+
+	var enumOpts *enum.Options
+	if cfg.Enum {
+		enumOpts = &enum.Options{Permute: cfg.EnumPermute, QPS: cfg.EnumQPS}
+		if cfg.EnumWordlist != "" {
+			if enumOpts.Words, err = enum.LoadWordlist(cfg.EnumWordlist); err != nil {
+				log.Fatal("load enum wordlist", "err", err)
+			}
+		}
+	}
+
+	probeOpts := &probe.Options{
+		MaxPages:           cfg.MaxPages,
+		RootCandidates:     cfg.RootCandidates,
+		Dialer:             egressDialer,
+		FetchEachIP:        cfg.FetchEachIP,
+		TLSFingerprint:     cfg.TLSFingerprint,
+		MXCerts:            cfg.MXCerts,
+		DefaultCerts:       cfg.DefaultCerts,
+		RootCAs:            roots,
+		Resolver:           resolver,
+		Takeover:           cfg.TakeoverCheck,
+		TakeoverSignatures: takeoverSignatures,
+		ZoneRecords:        cfg.ZoneRecords,
+		SuppressWildcard:   cfg.SuppressWildcard,
+		MMDB:               mmdb,
+		Providers:          classifier,
+		RDAP:               rdap,
+		RDAPQPS:            cfg.RDAPQPS,
+		Enum:               enumOpts,
+		AXFR:               cfg.AXFR,
+		Delegation:         cfg.DelegationCheck,
+	}
+	p := probe.New(cfg.UA, cfg.Probe, cfg.Run, cfg.ExcludeTLDs, d, batches, probeOpts, log)
 	p.Run(ctx, tasks, cfg.Concurrency)
 
 	// Wait for emitter to drain
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,6 +27,54 @@ type Config struct {
 	BatchMaxEdges  int `yaml:"batch_max_edges" json:"batch_max_edges"`
 	BatchFlushSec  int `yaml:"batch_flush_sec" json:"batch_flush_sec"`
 
+	// Crawl
+	MaxPages       int      `yaml:"max_pages" json:"max_pages"`
+	RootCandidates []string `yaml:"root_candidates" json:"root_candidates"`
+	FetchEachIP    bool     `yaml:"fetch_each_ip" json:"fetch_each_ip"`
+	TLSFingerprint bool     `yaml:"tls_fingerprint" json:"tls_fingerprint"`
+	MXCerts        bool     `yaml:"mx_certs" json:"mx_certs"`
+	DefaultCerts   bool     `yaml:"default_certs" json:"default_certs"`
+	TLSRoots       string   `yaml:"tls_roots" json:"tls_roots"`
+
+	// DNS
+	DNSServers       []string `yaml:"dns_servers" json:"dns_servers"`
+	ZoneRecords      bool     `yaml:"zone_records" json:"zone_records"`
+	SuppressWildcard bool     `yaml:"suppress_wildcard" json:"suppress_wildcard"`
+	AXFR             bool     `yaml:"axfr" json:"axfr"`
+	DelegationCheck  bool     `yaml:"delegation_check" json:"delegation_check"`
+
+	// Offline IP enrichment (MaxMind/IPinfo MMDB files)
+	MMDB []string `yaml:"mmdb" json:"mmdb"`
+
+	// Cloud/CDN provider attribution
+	Providers        bool     `yaml:"providers" json:"providers"`
+	ProviderRanges   []string `yaml:"provider_ranges" json:"provider_ranges"`
+	ProviderSuffixes string   `yaml:"provider_suffixes" json:"provider_suffixes"`
+
+	// Registration data (RDAP)
+	RDAPBootstrap string  `yaml:"rdap_bootstrap" json:"rdap_bootstrap"`
+	RDAPQPS       float64 `yaml:"rdap_qps" json:"rdap_qps"`
+
+	// DNS cache (TTLs in seconds; 0 uses the defaults)
+	DNSCache       bool `yaml:"dns_cache" json:"dns_cache"`
+	DNSCacheMinTTL int  `yaml:"dns_cache_min_ttl_sec" json:"dns_cache_min_ttl_sec"`
+	DNSCacheMaxTTL int  `yaml:"dns_cache_max_ttl_sec" json:"dns_cache_max_ttl_sec"`
+	DNSCacheNegTTL int  `yaml:"dns_cache_negative_ttl_sec" json:"dns_cache_negative_ttl_sec"`
+
+	// Subdomain enumeration
+	Enum         bool    `yaml:"enum" json:"enum"`
+	EnumWordlist string  `yaml:"enum_wordlist" json:"enum_wordlist"`
+	EnumPermute  bool    `yaml:"enum_permute" json:"enum_permute"`
+	EnumQPS      float64 `yaml:"enum_qps" json:"enum_qps"`
+
+	// Takeover detection
+	TakeoverCheck      bool   `yaml:"takeover_check" json:"takeover_check"`
+	TakeoverSignatures string `yaml:"takeover_signatures" json:"takeover_signatures"`
+
+	// Egress
+	Proxy    string `yaml:"proxy" json:"proxy"`
+	SourceIP string `yaml:"source_ip" json:"source_ip"`
+
 	// Output
 	Ingest       string `yaml:"ingest" json:"ingest"`
 	SpoolDir     string `yaml:"spool_dir" json:"spool_dir"`
@@ -71,6 +120,12 @@ func (c *Config) SetDefaults() {
 	if c.BatchFlushSec == 0 {
 		c.BatchFlushSec = 2
 	}
+	if c.MaxPages == 0 {
+		c.MaxPages = 1
+	}
+	if len(c.RootCandidates) == 0 {
+		c.RootCandidates = []string{"https:443", "http:80"}
+	}
 	if c.SpoolDir == "" {
 		c.SpoolDir = "spool"
 	}
@@ -102,9 +157,46 @@ func (c *Config) Validate() error {
 	if c.BatchFlushSec < 1 {
 		return fmt.Errorf("batch_flush_sec must be at least 1")
 	}
+	if c.MaxPages < 0 {
+		return fmt.Errorf("max_pages must not be negative")
+	}
+	for _, rc := range c.RootCandidates {
+		scheme, port, ok := strings.Cut(strings.ToLower(strings.TrimSpace(rc)), ":")
+		if n, err := strconv.Atoi(port); !ok || (scheme != "http" && scheme != "https") || err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("root_candidates entry %q must be http:<port> or https:<port>", rc)
+		}
+	}
+	for _, pr := range c.ProviderRanges {
+		if name, file, ok := strings.Cut(pr, "="); !ok || name == "" || file == "" {
+			return fmt.Errorf("provider_ranges entry %q must be provider=path", pr)
+		}
+	}
+	if c.DNSCacheMinTTL < 0 || c.DNSCacheMaxTTL < 0 || c.DNSCacheNegTTL < 0 {
+		return fmt.Errorf("dns_cache TTLs must not be negative")
+	}
+	// compared as the cache will apply them, zero meaning its default
+	minTTL, maxTTL, negTTL := c.dnsCacheTTLs()
+	if minTTL > maxTTL {
+		return fmt.Errorf("dns_cache_min_ttl_sec (%d) must not exceed dns_cache_max_ttl_sec (%d)", minTTL, maxTTL)
+	}
+	if minTTL > negTTL {
+		return fmt.Errorf("dns_cache_min_ttl_sec (%d) must not exceed dns_cache_negative_ttl_sec (%d)", minTTL, negTTL)
+	}
 	return nil
 }
 
+// dnsCacheTTLs returns the DNS cache TTL clamps in seconds with unset ones
+// replaced by the defaults dns.NewCache uses.
+func (c *Config) dnsCacheTTLs() (minTTL, maxTTL, negTTL int) {
+	or := func(v, def int) int {
+		if v == 0 {
+			return def
+		}
+		return v
+	}
+	return or(c.DNSCacheMinTTL, 5), or(c.DNSCacheMaxTTL, 3600), or(c.DNSCacheNegTTL, 300)
+}
+
 // LoadFromFile loads configuration from a YAML or JSON file
 func LoadFromFile(path string) (*Config, error) {
 	file, err := os.Open(path)
@@ -173,6 +265,96 @@ func (c *Config) MergeWithFlags(flags map[string]interface{}) {
 	if v, ok := flags["batch_flush_sec"].(int); ok && v > 0 {
 		c.BatchFlushSec = v
 	}
+	if v, ok := flags["max_pages"].(int); ok && v > 0 {
+		c.MaxPages = v
+	}
+	if v, ok := flags["root_candidates"].([]string); ok && len(v) > 0 {
+		c.RootCandidates = v
+	}
+	if v, ok := flags["fetch_each_ip"].(bool); ok && v {
+		c.FetchEachIP = true
+	}
+	if v, ok := flags["tls_fingerprint"].(bool); ok && v {
+		c.TLSFingerprint = true
+	}
+	if v, ok := flags["mx_certs"].(bool); ok && v {
+		c.MXCerts = true
+	}
+	if v, ok := flags["default_certs"].(bool); ok && v {
+		c.DefaultCerts = true
+	}
+	if v, ok := flags["tls_roots"].(string); ok && v != "" {
+		c.TLSRoots = v
+	}
+	if v, ok := flags["dns_servers"].([]string); ok && len(v) > 0 {
+		c.DNSServers = v
+	}
+	if v, ok := flags["zone_records"].(bool); ok && v {
+		c.ZoneRecords = true
+	}
+	if v, ok := flags["axfr"].(bool); ok && v {
+		c.AXFR = true
+	}
+	if v, ok := flags["delegation_check"].(bool); ok && v {
+		c.DelegationCheck = true
+	}
+	if v, ok := flags["mmdb"].([]string); ok && len(v) > 0 {
+		c.MMDB = v
+	}
+	if v, ok := flags["providers"].(bool); ok && v {
+		c.Providers = true
+	}
+	if v, ok := flags["provider_ranges"].([]string); ok && len(v) > 0 {
+		c.ProviderRanges = v
+	}
+	if v, ok := flags["provider_suffixes"].(string); ok && v != "" {
+		c.ProviderSuffixes = v
+	}
+	if v, ok := flags["rdap_bootstrap"].(string); ok && v != "" {
+		c.RDAPBootstrap = v
+	}
+	if v, ok := flags["rdap_qps"].(float64); ok && v > 0 {
+		c.RDAPQPS = v
+	}
+	if v, ok := flags["dns_cache"].(bool); ok && v {
+		c.DNSCache = true
+	}
+	if v, ok := flags["dns_cache_min_ttl_sec"].(int); ok && v > 0 {
+		c.DNSCacheMinTTL = v
+	}
+	if v, ok := flags["dns_cache_max_ttl_sec"].(int); ok && v > 0 {
+		c.DNSCacheMaxTTL = v
+	}
+	if v, ok := flags["dns_cache_negative_ttl_sec"].(int); ok && v > 0 {
+		c.DNSCacheNegTTL = v
+	}
+	if v, ok := flags["suppress_wildcard"].(bool); ok && v {
+		c.SuppressWildcard = true
+	}
+	if v, ok := flags["enum"].(bool); ok && v {
+		c.Enum = true
+	}
+	if v, ok := flags["enum_wordlist"].(string); ok && v != "" {
+		c.EnumWordlist = v
+	}
+	if v, ok := flags["enum_permute"].(bool); ok && v {
+		c.EnumPermute = true
+	}
+	if v, ok := flags["enum_qps"].(float64); ok && v > 0 {
+		c.EnumQPS = v
+	}
+	if v, ok := flags["takeover_check"].(bool); ok && v {
+		c.TakeoverCheck = true
+	}
+	if v, ok := flags["takeover_signatures"].(string); ok && v != "" {
+		c.TakeoverSignatures = v
+	}
+	if v, ok := flags["proxy"].(string); ok && v != "" {
+		c.Proxy = v
+	}
+	if v, ok := flags["source_ip"].(string); ok && v != "" {
+		c.SourceIP = v
+	}
 	if v, ok := flags["spool_dir"].(string); ok && v != "" {
 		c.SpoolDir = v
 	}
@@ -106,6 +106,9 @@ func TestSetDefaults(t *testing.T) {
 	if len(cfg.ExcludeTLDs) != 3 {
 		t.Errorf("expected 3 default excluded TLDs, got %d", len(cfg.ExcludeTLDs))
 	}
+	if cfg.MaxPages != 1 {
+		t.Errorf("expected default max_pages 1, got %d", cfg.MaxPages)
+	}
 }
 
 func TestValidate(t *testing.T) {
@@ -153,6 +156,108 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid root_candidates",
+			cfg: Config{
+				Domains:        "domains.txt",
+				Concurrency:    256,
+				BatchMaxEdges:  10000,
+				BatchFlushSec:  2,
+				RootCandidates: []string{"https:443", "ftp:21"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "root_candidates port out of range",
+			cfg: Config{
+				Domains:        "domains.txt",
+				Concurrency:    256,
+				BatchMaxEdges:  10000,
+				BatchFlushSec:  2,
+				RootCandidates: []string{"http:80", "https:70000"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "dns_cache min TTL above max",
+			cfg: Config{
+				Domains:        "domains.txt",
+				Concurrency:    256,
+				BatchMaxEdges:  10000,
+				BatchFlushSec:  2,
+				DNSCacheMinTTL: 600,
+				DNSCacheMaxTTL: 60,
+			},
+			wantErr: true,
+		},
+		{
+			name: "dns_cache min TTL above default max",
+			cfg: Config{
+				Domains:        "domains.txt",
+				Concurrency:    256,
+				BatchMaxEdges:  10000,
+				BatchFlushSec:  2,
+				DNSCacheMinTTL: 7200,
+			},
+			wantErr: true,
+		},
+		{
+			name: "dns_cache min TTL above negative TTL",
+			cfg: Config{
+				Domains:        "domains.txt",
+				Concurrency:    256,
+				BatchMaxEdges:  10000,
+				BatchFlushSec:  2,
+				DNSCacheMinTTL: 600,
+				DNSCacheNegTTL: 60,
+			},
+			wantErr: true,
+		},
+		{
+			name: "dns_cache min TTL within defaults",
+			cfg: Config{
+				Domains:        "domains.txt",
+				Concurrency:    256,
+				BatchMaxEdges:  10000,
+				BatchFlushSec:  2,
+				DNSCacheMinTTL: 60,
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative dns_cache TTL",
+			cfg: Config{
+				Domains:        "domains.txt",
+				Concurrency:    256,
+				BatchMaxEdges:  10000,
+				BatchFlushSec:  2,
+				DNSCacheNegTTL: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid provider_ranges",
+			cfg: Config{
+				Domains:        "domains.txt",
+				Concurrency:    256,
+				BatchMaxEdges:  10000,
+				BatchFlushSec:  2,
+				ProviderRanges: []string{"aws=ip-ranges.json", "cloudflare-ips.txt"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid candidates and ranges",
+			cfg: Config{
+				Domains:        "domains.txt",
+				Concurrency:    256,
+				BatchMaxEdges:  10000,
+				BatchFlushSec:  2,
+				RootCandidates: []string{"https:443", "HTTP:8080"},
+				ProviderRanges: []string{"aws=ip-ranges.json"},
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
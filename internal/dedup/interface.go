@@ -2,4 +2,7 @@ package dedup
 
 type Interface interface {
 	Seen(key string) bool
+	// Has reports whether key was seen without marking it, for work that
+	// should only count as done once it succeeds.
+	Has(key string) bool
 }
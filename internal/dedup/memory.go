@@ -10,3 +10,8 @@ func (d *Memory) Seen(key string) bool {
 	_, ok := d.m.LoadOrStore(key, struct{}{})
 	return ok
 }
+
+func (d *Memory) Has(key string) bool {
+	_, ok := d.m.Load(key)
+	return ok
+}
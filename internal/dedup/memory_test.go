@@ -73,4 +73,17 @@ func BenchmarkMemory_Seen(b *testing.B) {
 			d.Seen("benchmark")
 		}
 	})
-}
\ No newline at end of file
+}
+
+func TestMemory_Has(t *testing.T) {
+	d := NewMemory()
+	if d.Has("k") {
+		t.Error("expected false before the key is marked")
+	}
+	if d.Has("k") || d.Seen("k") {
+		t.Error("expected Has not to mark the key")
+	}
+	if !d.Has("k") {
+		t.Error("expected true once the key is marked")
+	}
+}
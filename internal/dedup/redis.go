@@ -33,3 +33,18 @@ func (r *Redis) Seen(key string) bool {
 	}
 	return !ok
 }
+
+func (r *Redis) Has(key string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	n, err := r.cli.Exists(ctx, "seen:"+key).Result()
+	if err != nil {
+		r.errorCount++
+		if r.errorCount%100 == 1 {
+			log.Printf("Redis dedup error (count: %d): %v", r.errorCount, err)
+		}
+		return false
+	}
+	return n > 0
+}
@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Zone transfer outcomes.
+const (
+	AXFRAllowed = "allowed"
+	AXFRRefused = "refused"
+	AXFRFailed  = "failed" // unreachable, timed out or malformed
+)
+
+// maxAXFRRecords stops runaway transfers from huge zones.
+const maxAXFRRecords = 100000
+
+// Transfer is the result of one AXFR attempt.
+type Transfer struct {
+	Zone    string
+	Status  string
+	Err     error
+	Records []dnsmessage.Resource
+}
+
+// AXFR requests a full transfer of zone from the name server at addr
+// (host:port) over TCP and collects every record up to the closing SOA.
+func (r *Resolver) AXFR(ctx context.Context, zone, addr string) Transfer {
+	q, err := dnsmessage.NewName(fqdn(zone))
+	if err != nil { return Transfer{Status: AXFRFailed, Err: err} }
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	dial := r.Dial
+	if dial == nil { dial = (&net.Dialer{}).DialContext }
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil { return Transfer{Status: AXFRFailed, Err: err} }
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok { conn.SetDeadline(dl) }
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	id := queryID()
+	req := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id},
+		Questions: []dnsmessage.Question{{Name: q, Type: dnsmessage.TypeAXFR, Class: dnsmessage.ClassINET}},
+	}
+	b, err := req.Pack()
+	if err != nil { return Transfer{Status: AXFRFailed, Err: err} }
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)); err != nil {
+		return Transfer{Status: AXFRFailed, Err: err}
+	}
+
+	var t Transfer
+	soas := 0
+	for soas < 2 {
+		var n [2]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			// servers commonly refuse by closing the connection
+			if len(t.Records) == 0 && errors.Is(err, io.EOF) { return Transfer{Status: AXFRRefused, Err: err} }
+			return Transfer{Status: AXFRFailed, Err: err}
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(n[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil { return Transfer{Status: AXFRFailed, Err: err} }
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf); err != nil { return Transfer{Status: AXFRFailed, Err: err} }
+		if msg.Header.ID != id { return Transfer{Status: AXFRFailed, Err: fmt.Errorf("dns: mismatched axfr response from %s", addr)} }
+		if msg.Header.RCode != dnsmessage.RCodeSuccess {
+			return Transfer{Status: AXFRRefused, Err: fmt.Errorf("dns: axfr %s from %s: %s", zone, addr, msg.Header.RCode)}
+		}
+		if len(t.Records) == 0 && (len(msg.Answers) == 0 || msg.Answers[0].Header.Type != dnsmessage.TypeSOA) {
+			return Transfer{Status: AXFRRefused, Err: fmt.Errorf("dns: axfr %s from %s did not start with SOA", zone, addr)}
+		}
+		for _, res := range msg.Answers {
+			if res.Header.Type == dnsmessage.TypeSOA { soas++ }
+			t.Records = append(t.Records, res)
+		}
+		if len(t.Records) > maxAXFRRecords { return Transfer{Status: AXFRFailed, Err: fmt.Errorf("dns: axfr %s exceeds %d records", zone, maxAXFRRecords)} }
+	}
+	t.Zone, t.Status = strings.ToLower(strings.TrimSuffix(zone, ".")), AXFRAllowed
+	return t
+}
+
+// ZoneEntry is one relationship taken from a transferred zone.
+type ZoneEntry struct {
+	Name   string
+	Type   string // A, AAAA, CNAME, MX or NS
+	Target string
+}
+
+// Entries flattens the transfer into the owner names and the records that
+// map onto graph edges; other types only contribute their owner name.
+// Records owned outside the zone are ignored.
+func (t Transfer) Entries() (names []string, entries []ZoneEntry) {
+	seen := make(map[string]bool)
+	for _, res := range t.Records {
+		name := trimName(res.Header.Name)
+		if !isSubdomain(name, t.Zone) { continue }
+		if !seen[name] { seen[name] = true; names = append(names, name) }
+		e := ZoneEntry{Name: name}
+		switch b := res.Body.(type) {
+		case *dnsmessage.AResource:
+			e.Type, e.Target = "A", net.IP(b.A[:]).String()
+		case *dnsmessage.AAAAResource:
+			e.Type, e.Target = "AAAA", net.IP(b.AAAA[:]).String()
+		case *dnsmessage.CNAMEResource:
+			e.Type, e.Target = "CNAME", trimName(b.CNAME)
+		case *dnsmessage.MXResource:
+			e.Type, e.Target = "MX", trimName(b.MX)
+		case *dnsmessage.NSResource:
+			e.Type, e.Target = "NS", trimName(b.NS)
+		default:
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return names, entries
+}
+
+// isSubdomain reports whether name is zone or beneath it.
+func isSubdomain(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
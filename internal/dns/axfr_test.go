@@ -0,0 +1,131 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gustycube/spyder/internal/dns/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// authServer is a TCP authoritative stand-in. It answers AXFR with msgs,
+// one DNS message per entry, each echoing the request ID and question.
+// A nil msgs closes the connection without answering.
+func authServer(t *testing.T, rcode dnsmessage.RCode, msgs [][]dnsmessage.Resource) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var n [2]byte
+				if _, err := io.ReadFull(c, n[:]); err != nil {
+					return
+				}
+				buf := make([]byte, binary.BigEndian.Uint16(n[:]))
+				if _, err := io.ReadFull(c, buf); err != nil {
+					return
+				}
+				var req dnsmessage.Message
+				if req.Unpack(buf) != nil || req.Questions[0].Type != dnsmessage.TypeAXFR {
+					return
+				}
+				if rcode != dnsmessage.RCodeSuccess {
+					msgs = [][]dnsmessage.Resource{nil}
+				}
+				for _, answers := range msgs {
+					resp := dnsmessage.Message{
+						Header:    dnsmessage.Header{ID: req.Header.ID, Response: true, Authoritative: true, RCode: rcode},
+						Questions: req.Questions,
+						Answers:   answers,
+					}
+					b, _ := resp.Pack()
+					c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+				}
+			}(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func transferZone() [][]dnsmessage.Resource {
+	n := dnsmessage.MustNewName
+	soa := dnstest.RR("corp.test.", &dnsmessage.SOAResource{NS: n("ns1.corp.test."), MBox: n("hostmaster.corp.test."), Serial: 7})
+	return [][]dnsmessage.Resource{
+		{
+			soa,
+			dnstest.RR("corp.test.", &dnsmessage.NSResource{NS: n("ns1.corp.test.")}),
+			dnstest.RR("corp.test.", &dnsmessage.MXResource{Pref: 10, MX: n("mx.corp.test.")}),
+			dnstest.RR("ns1.corp.test.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 53}}),
+		},
+		{
+			dnstest.RR("intranet.corp.test.", &dnsmessage.AResource{A: [4]byte{10, 0, 0, 5}}),
+			dnstest.RR("vpn.corp.test.", &dnsmessage.CNAMEResource{CNAME: n("gw.vendor.net.")}),
+			dnstest.RR("lab.corp.test.", &dnsmessage.NSResource{NS: n("ns.lab.corp.test.")}),
+			dnstest.RR("corp.test.", &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all"}}),
+			soa,
+		},
+	}
+}
+
+func TestAXFR_Allowed(t *testing.T) {
+	addr := authServer(t, dnsmessage.RCodeSuccess, transferZone())
+	r := NewResolver([]string{"127.0.0.1:1"})
+
+	tr := r.AXFR(context.Background(), "corp.test", addr)
+	if tr.Status != AXFRAllowed {
+		t.Fatalf("expected transfer allowed, got %s (%v)", tr.Status, tr.Err)
+	}
+	if len(tr.Records) != 9 {
+		t.Errorf("expected 9 records across both messages, got %d", len(tr.Records))
+	}
+
+	names, entries := tr.Entries()
+	sort.Strings(names)
+	want := "corp.test intranet.corp.test lab.corp.test ns1.corp.test vpn.corp.test"
+	if strings.Join(names, " ") != want {
+		t.Errorf("expected names %s, got %v", want, names)
+	}
+	got := map[string]bool{}
+	for _, e := range entries {
+		got[e.Name+" "+e.Type+" "+e.Target] = true
+	}
+	for _, w := range []string{"intranet.corp.test A 10.0.0.5", "vpn.corp.test CNAME gw.vendor.net", "corp.test MX mx.corp.test", "lab.corp.test NS ns.lab.corp.test"} {
+		if !got[w] {
+			t.Errorf("expected entry %q, got %v", w, entries)
+		}
+	}
+}
+
+func TestAXFR_Refused(t *testing.T) {
+	r := NewResolver([]string{"127.0.0.1:1"})
+
+	refused := authServer(t, dnsmessage.RCodeRefused, nil)
+	if tr := r.AXFR(context.Background(), "corp.test", refused); tr.Status != AXFRRefused {
+		t.Errorf("expected refused rcode to count as refused, got %s (%v)", tr.Status, tr.Err)
+	}
+	closed := authServer(t, dnsmessage.RCodeSuccess, nil)
+	if tr := r.AXFR(context.Background(), "corp.test", closed); tr.Status != AXFRRefused {
+		t.Errorf("expected a closed connection to count as refused, got %s (%v)", tr.Status, tr.Err)
+	}
+
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead := ln.Addr().String()
+	ln.Close()
+	if tr := r.AXFR(context.Background(), "corp.test", dead); tr.Status != AXFRFailed {
+		t.Errorf("expected an unreachable server to fail, got %s", tr.Status)
+	}
+}
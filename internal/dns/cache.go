@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gustycube/spyder/internal/metrics"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultCacheEntries is the Cache size NewCache starts with.
+const defaultCacheEntries = 100000
+
+// Cache holds recursive answers for as long as their TTL allows. It is
+// safe for concurrent use and meant to be shared by every worker. Its size
+// is bounded; once full, the least recently used answer makes room.
+type Cache struct {
+	// MinTTL and MaxTTL clamp the lifetime of every entry.
+	MinTTL, MaxTTL time.Duration
+	// NegativeTTL caps how long NXDOMAIN and NODATA answers are kept, and
+	// is used as is when the answer carries no SOA to take it from.
+	NegativeTTL time.Duration
+
+	entries *lru.Cache[cacheKey, cacheEntry]
+	group   singleflight.Group
+	now     func() time.Time
+}
+
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+}
+
+type cacheEntry struct {
+	ans     *Answer
+	expires time.Time
+}
+
+// NewCache returns a Cache with the given clamps; zero values select 5s,
+// 1h and 5m. It holds up to 100000 answers.
+func NewCache(minTTL, maxTTL, negativeTTL time.Duration) *Cache {
+	if minTTL <= 0 { minTTL = 5 * time.Second }
+	if maxTTL <= 0 { maxTTL = time.Hour }
+	if negativeTTL <= 0 { negativeTTL = 5 * time.Minute }
+	entries, _ := lru.New[cacheKey, cacheEntry](defaultCacheEntries)
+	return &Cache{MinTTL: minTTL, MaxTTL: maxTTL, NegativeTTL: negativeTTL, entries: entries, now: time.Now}
+}
+
+// SetMaxEntries changes how many answers the cache holds, evicting the
+// least recently used ones if it shrinks.
+func (c *Cache) SetMaxEntries(n int) {
+	c.entries.Resize(max(n, 1))
+}
+
+// Len returns the number of entries, expired or not.
+func (c *Cache) Len() int {
+	return c.entries.Len()
+}
+
+// lookup answers from the cache or, on a miss, through fetch, coalescing
+// concurrent misses for the same question into one fetch. The fetch is
+// shared, so it runs detached from the cancellation of whichever caller
+// started it, bounded by timeout instead; a caller whose ctx ends stops
+// waiting without failing the others.
+func (c *Cache) lookup(ctx context.Context, name string, qtype dnsmessage.Type, timeout time.Duration, fetch func(context.Context, string, dnsmessage.Type) (*Answer, error)) (*Answer, error) {
+	key := cacheKey{strings.ToLower(fqdn(name)), qtype}
+	if ans, ok := c.load(key); ok {
+		metrics.DNSCache.WithLabelValues("hit").Inc()
+		return ans, nil
+	}
+	ch := c.group.DoChan(key.name+"|"+qtype.String(), func() (any, error) {
+		// another flight may have filled the entry since load
+		if ans, ok := c.load(key); ok { return ans, nil }
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		ans, err := fetch(fctx, name, qtype)
+		if err == nil { c.store(key, ans) }
+		return ans, err
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.Shared {
+		metrics.DNSCache.WithLabelValues("coalesced").Inc()
+	} else {
+		metrics.DNSCache.WithLabelValues("miss").Inc()
+	}
+	if res.Err != nil { return nil, res.Err }
+	return res.Val.(*Answer), nil
+}
+
+func (c *Cache) load(key cacheKey) (*Answer, bool) {
+	e, ok := c.entries.Get(key)
+	if !ok { return nil, false }
+	if !c.now().Before(e.expires) { c.entries.Remove(key); return nil, false }
+	return e.ans, true
+}
+
+func (c *Cache) store(key cacheKey, ans *Answer) {
+	ttl, ok := c.ttl(ans)
+	if !ok { return }
+	c.entries.Add(key, cacheEntry{ans: ans, expires: c.now().Add(ttl)})
+}
+
+// ttl returns how long ans may be cached: the smallest record TTL for
+// positive answers, the SOA's negative TTL (RFC 2308) for NXDOMAIN and
+// NODATA, and not at all for failures.
+func (c *Cache) ttl(ans *Answer) (time.Duration, bool) {
+	if ans.RCode == dnsmessage.RCodeSuccess && len(ans.Resources) > 0 {
+		least := uint32(math.MaxUint32)
+		for _, res := range ans.Resources {
+			least = min(least, res.Header.TTL)
+		}
+		return clamp(time.Duration(least)*time.Second, c.MinTTL, c.MaxTTL), true
+	}
+	if ans.RCode != dnsmessage.RCodeSuccess && ans.RCode != dnsmessage.RCodeNameError { return 0, false }
+	neg := c.NegativeTTL
+	for _, res := range ans.authorities {
+		if soa, ok := res.Body.(*dnsmessage.SOAResource); ok {
+			neg = min(neg, time.Duration(min(res.Header.TTL, soa.MinTTL))*time.Second)
+		}
+	}
+	return clamp(neg, c.MinTTL, c.NegativeTTL), true
+}
+
+func clamp(d, lo, hi time.Duration) time.Duration {
+	return max(lo, min(d, hi))
+}
@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gustycube/spyder/internal/dns/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// clock is a settable time source for cache expiry.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func cachedResolver(t *testing.T, f *dnstest.Server) (*Resolver, *clock) {
+	clk := &clock{t: time.Unix(1_700_000_000, 0)}
+	r := NewResolver([]string{f.Start(t)})
+	r.Cache = NewCache(time.Minute, time.Hour, 10*time.Minute)
+	r.Cache.now = clk.now
+	return r, clk
+}
+
+func TestCache_HonorsTTL(t *testing.T) {
+	f := exampleZone()
+	r, clk := cachedResolver(t, f)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if ans, err := r.Query(ctx, "WWW.example.com", dnsmessage.TypeA); err != nil || len(ans.Resources) != 2 {
+			t.Fatalf("expected the CNAME and A answer, got %v, %v", ans, err)
+		}
+	}
+	if n := atomic.LoadInt32(&f.Queries); n != 1 {
+		t.Errorf("expected repeat queries to be served from cache, server saw %d", n)
+	}
+
+	// records carry a 300s TTL
+	clk.t = clk.t.Add(299 * time.Second)
+	r.Query(ctx, "www.example.com", dnsmessage.TypeA)
+	if n := atomic.LoadInt32(&f.Queries); n != 1 {
+		t.Errorf("expected the entry to live for its TTL, server saw %d", n)
+	}
+	clk.t = clk.t.Add(2 * time.Second)
+	r.Query(ctx, "www.example.com", dnsmessage.TypeA)
+	if n := atomic.LoadInt32(&f.Queries); n != 2 {
+		t.Errorf("expected an expired entry to be refetched, server saw %d", n)
+	}
+}
+
+func TestCache_ClampsTTL(t *testing.T) {
+	c := NewCache(time.Minute, time.Hour, 10*time.Minute)
+	answer := func(ttl uint32) *Answer {
+		res := dnstest.RR("a.example.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})
+		res.Header.TTL = ttl
+		return &Answer{Resources: []dnsmessage.Resource{res}}
+	}
+	cases := []struct {
+		ttl  uint32
+		want time.Duration
+	}{
+		{0, time.Minute},
+		{600, 10 * time.Minute},
+		{86400, time.Hour},
+	}
+	for _, tc := range cases {
+		if got, ok := c.ttl(answer(tc.ttl)); !ok || got != tc.want {
+			t.Errorf("TTL %d: expected %v, got %v (%v)", tc.ttl, tc.want, got, ok)
+		}
+	}
+}
+
+func TestCache_Negative(t *testing.T) {
+	soa := dnstest.RR("example.com.", &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns1.example.com."), MBox: dnsmessage.MustNewName("hostmaster.example.com."), MinTTL: 120})
+	f := &dnstest.Server{
+		RCodes: map[string]dnsmessage.RCode{
+			"gone.example.com.":   dnsmessage.RCodeNameError,
+			"broken.example.com.": dnsmessage.RCodeServerFailure,
+		},
+		Authority: map[dnstest.Key][]dnsmessage.Resource{{Name: "gone.example.com.", Type: dnsmessage.TypeA}: {soa}},
+	}
+	r, clk := cachedResolver(t, f)
+	ctx := context.Background()
+
+	r.Query(ctx, "gone.example.com", dnsmessage.TypeA)
+	ans, err := r.Query(ctx, "gone.example.com", dnsmessage.TypeA)
+	if err != nil || ans.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("expected a cached NXDOMAIN, got %v, %v", ans, err)
+	}
+	if n := atomic.LoadInt32(&f.Queries); n != 1 {
+		t.Errorf("expected NXDOMAIN to be cached, server saw %d", n)
+	}
+	// the SOA minimum bounds negative answers
+	clk.t = clk.t.Add(121 * time.Second)
+	r.Query(ctx, "gone.example.com", dnsmessage.TypeA)
+	if n := atomic.LoadInt32(&f.Queries); n != 2 {
+		t.Errorf("expected NXDOMAIN to expire after the SOA minimum, server saw %d", n)
+	}
+
+	// NODATA without an SOA uses NegativeTTL
+	r.Query(ctx, "nodata.example.com", dnsmessage.TypeA)
+	clk.t = clk.t.Add(9 * time.Minute)
+	r.Query(ctx, "nodata.example.com", dnsmessage.TypeA)
+	if n := atomic.LoadInt32(&f.Queries); n != 3 {
+		t.Errorf("expected NODATA to be cached for NegativeTTL, server saw %d", n)
+	}
+
+	r.Query(ctx, "broken.example.com", dnsmessage.TypeA)
+	r.Query(ctx, "broken.example.com", dnsmessage.TypeA)
+	if n := atomic.LoadInt32(&f.Queries); n != 5 {
+		t.Errorf("expected SERVFAIL never to be cached, server saw %d", n)
+	}
+}
+
+func TestCache_Coalesces(t *testing.T) {
+	c := NewCache(0, 0, 0)
+	var calls int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context, name string, qtype dnsmessage.Type) (*Answer, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &Answer{Resources: []dnsmessage.Resource{dnstest.RR(fqdn(name), &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})}}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ans, err := c.lookup(context.Background(), "shared.example.com", dnsmessage.TypeA, time.Second, fetch); err != nil || len(ans.Resources) != 1 {
+				t.Errorf("expected the shared answer, got %v, %v", ans, err)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expected concurrent misses to share one fetch, got %d", calls)
+	}
+	if c.Len() != 1 {
+		t.Errorf("expected one entry, got %d", c.Len())
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(0, 0, 0)
+	c.SetMaxEntries(2)
+	fetch := func(ctx context.Context, name string, qtype dnsmessage.Type) (*Answer, error) {
+		return &Answer{}, nil
+	}
+	lookup := func(name string) {
+		c.lookup(context.Background(), name, dnsmessage.TypeA, time.Second, fetch)
+	}
+	lookup("a.example")
+	lookup("b.example")
+	lookup("a.example") // a is now more recent than b
+	lookup("c.example")
+	if c.Len() != 2 {
+		t.Errorf("expected the cache to stay at 2 entries, got %d", c.Len())
+	}
+	for name, want := range map[string]bool{"a.example.": true, "b.example.": false, "c.example.": true} {
+		if _, ok := c.load(cacheKey{name, dnsmessage.TypeA}); ok != want {
+			t.Errorf("expected %s cached=%v", name, want)
+		}
+	}
+}
+
+func TestCache_DetachesFlight(t *testing.T) {
+	c := NewCache(0, 0, 0)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(ctx context.Context, name string, qtype dnsmessage.Type) (*Answer, error) {
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return &Answer{Resources: []dnsmessage.Resource{dnstest.RR(fqdn(name), &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})}}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.lookup(ctx, "shared.example.com", dnsmessage.TypeA, time.Second, fetch)
+		first <- err
+	}()
+	<-started
+	second := make(chan *Answer, 1)
+	go func() {
+		ans, _ := c.lookup(context.Background(), "shared.example.com", dnsmessage.TypeA, time.Second, fetch)
+		second <- ans
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("expected the cancelled caller to give up, got %v", err)
+	}
+	close(release)
+	if ans := <-second; ans == nil || len(ans.Resources) != 1 {
+		t.Errorf("expected the other caller to get the answer, got %+v", ans)
+	}
+}
@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Authoritative server health, as seen by querying the server directly.
+const (
+	NSHealthy      = "ok"
+	NSLame         = "lame"         // answers, but not authoritatively for the zone
+	NSUnreachable  = "unreachable"  // no address answered
+	NSUnresolvable = "unresolvable" // the server's name has no address
+	// NSTimeout means every address timed out. That is often transient, so
+	// unlike the statuses above it is not reported as a lame delegation.
+	NSTimeout = "timeout"
+)
+
+// Delegation issue kinds, reported as findings.
+const (
+	IssueLame        = "LAME_DELEGATION"
+	IssueNSMismatch  = "NS_MISMATCH"
+	IssueSerialDrift = "SOA_SERIAL_DRIFT"
+)
+
+// AuthCheck is what one name server said about the zone when asked
+// directly.
+type AuthCheck struct {
+	NS     string
+	Addr   string // host:port last queried
+	Status string
+	NSSet  []string // sorted NS names in its answer
+	Serial uint32
+	HasSOA bool
+}
+
+// Delegation compares the parent zone's referral with what the zone's own
+// servers answer.
+type Delegation struct {
+	Zone string
+	// Parent is the sorted NS set the parent zone delegates to; nil when
+	// no parent server answered.
+	Parent  []string
+	Servers []AuthCheck
+}
+
+// Issue is one delegation problem tied to a name server.
+type Issue struct {
+	Kind     string
+	NS       string
+	Evidence []string
+}
+
+// QueryServer sends one non-recursive query to server (host:port).
+func (r *Resolver) QueryServer(ctx context.Context, server, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	q, err := dnsmessage.NewName(fqdn(name))
+	if err != nil { return nil, err }
+	return r.exchange(ctx, server, q, qtype, false)
+}
+
+// CheckDelegation asks a parent zone server for the zone's referral, then
+// queries every name server named by the parent or the zone itself for NS
+// and SOA without recursion.
+func (r *Resolver) CheckDelegation(ctx context.Context, zone string) Delegation {
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	d := Delegation{Zone: zone, Parent: r.parentNS(ctx, zone)}
+	names := append([]string(nil), d.Parent...)
+	if ans, err := r.Query(ctx, zone, dnsmessage.TypeNS); err == nil {
+		for _, ns := range nsNames(ans.Resources, zone) { names = appendUnique(names, ns) }
+	}
+	for _, ns := range names {
+		d.Servers = append(d.Servers, r.checkAuth(ctx, zone, ns))
+	}
+	return d
+}
+
+// parentNS finds the closest enclosing zone with name servers and returns
+// the NS set the first responsive one refers zone to.
+func (r *Resolver) parentNS(ctx context.Context, zone string) []string {
+	labels := strings.Split(zone, ".")
+	for i := 1; i < len(labels); i++ {
+		ans, err := r.Query(ctx, strings.Join(labels[i:], "."), dnsmessage.TypeNS)
+		if err != nil || !hasType(ans, dnsmessage.TypeNS) { continue }
+		for _, res := range ans.Resources {
+			ns, ok := res.Body.(*dnsmessage.NSResource)
+			if !ok { continue }
+			addrs, _ := r.LookupAddrs(ctx, trimName(ns.NS), false)
+			v6, _ := r.LookupAddrs(ctx, trimName(ns.NS), true)
+			for _, ip := range append(addrs, v6...) {
+				msg, err := r.QueryServer(ctx, net.JoinHostPort(ip, "53"), zone, dnsmessage.TypeNS)
+				if err != nil || msg.Header.RCode != dnsmessage.RCodeSuccess { continue }
+				// a referral carries the NS set in the authority section
+				set := nsNames(append(msg.Answers, msg.Authorities...), zone)
+				if len(set) > 0 { sort.Strings(set); return set }
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// checkAuth queries ns for the zone's NS and SOA at each of its addresses
+// until one answers. A server none of whose addresses answered is
+// unreachable if any of them refused or failed outright, and timed out if
+// they all just stayed silent.
+func (r *Resolver) checkAuth(ctx context.Context, zone, ns string) AuthCheck {
+	c := AuthCheck{NS: ns, Status: NSUnresolvable}
+	addrs, _ := r.LookupAddrs(ctx, ns, false)
+	v6, _ := r.LookupAddrs(ctx, ns, true)
+	for _, ip := range append(addrs, v6...) {
+		c.Addr = net.JoinHostPort(ip, "53")
+		msg, err := r.QueryServer(ctx, c.Addr, zone, dnsmessage.TypeNS)
+		if err != nil {
+			if status(nil, err) != StatusTimeout { c.Status = NSUnreachable } else if c.Status != NSUnreachable { c.Status = NSTimeout }
+			continue
+		}
+		if msg.Header.RCode != dnsmessage.RCodeSuccess || !msg.Header.Authoritative { c.Status = NSLame; return c }
+		c.Status = NSHealthy
+		c.NSSet = nsNames(msg.Answers, zone)
+		sort.Strings(c.NSSet)
+		if soa, err := r.QueryServer(ctx, c.Addr, zone, dnsmessage.TypeSOA); err == nil && soa.Header.Authoritative {
+			for _, res := range soa.Answers {
+				if b, ok := res.Body.(*dnsmessage.SOAResource); ok { c.Serial, c.HasSOA = b.Serial, true; break }
+			}
+		}
+		return c
+	}
+	return c
+}
+
+// Issues lists lame or unreachable servers, servers whose NS set differs
+// from the parent's, and servers serving an older SOA serial than the
+// newest one seen. Servers that only timed out are left out.
+func (d Delegation) Issues() []Issue {
+	var out []Issue
+	var newest uint32
+	for _, s := range d.Servers {
+		if s.HasSOA && s.Serial > newest { newest = s.Serial }
+	}
+	parent := strings.Join(d.Parent, ",")
+	for _, s := range d.Servers {
+		if s.Status == NSTimeout { continue }
+		if s.Status != NSHealthy {
+			ev := []string{"status:" + s.Status}
+			if s.Addr != "" { ev = append(ev, "addr:"+s.Addr) }
+			out = append(out, Issue{Kind: IssueLame, NS: s.NS, Evidence: ev})
+			continue
+		}
+		if d.Parent != nil && strings.Join(s.NSSet, ",") != parent {
+			out = append(out, Issue{Kind: IssueNSMismatch, NS: s.NS, Evidence: []string{"parent:" + parent, "child:" + strings.Join(s.NSSet, ",")}})
+		}
+		if s.HasSOA && s.Serial < newest {
+			out = append(out, Issue{Kind: IssueSerialDrift, NS: s.NS, Evidence: []string{fmt.Sprintf("serial:%d", s.Serial), fmt.Sprintf("newest:%d", newest)}})
+		}
+	}
+	return out
+}
+
+// nsNames returns the NS targets owned by zone.
+func nsNames(rs []dnsmessage.Resource, zone string) []string {
+	var out []string
+	for _, res := range rs {
+		ns, ok := res.Body.(*dnsmessage.NSResource)
+		if !ok || trimName(res.Header.Name) != zone { continue }
+		out = appendUnique(out, trimName(ns.NS))
+	}
+	return out
+}
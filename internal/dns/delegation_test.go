@@ -0,0 +1,143 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gustycube/spyder/internal/dns/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func nsSet(zone string, hosts ...string) []dnsmessage.Resource {
+	var out []dnsmessage.Resource
+	for _, h := range hosts {
+		out = append(out, dnstest.RR(zone, &dnsmessage.NSResource{NS: dnsmessage.MustNewName(h)}))
+	}
+	return out
+}
+
+func authZone(serial uint32, ns ...string) *dnstest.Server {
+	return &dnstest.Server{Auth: true, Records: map[dnstest.Key][]dnsmessage.Resource{
+		{Name: "corp.test.", Type: dnsmessage.TypeNS}:  nsSet("corp.test.", ns...),
+		{Name: "corp.test.", Type: dnsmessage.TypeSOA}: {dnstest.RR("corp.test.", &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns1.corp.test."), MBox: dnsmessage.MustNewName("hostmaster.corp.test."), Serial: serial})},
+	}}
+}
+
+func TestResolver_CheckDelegation(t *testing.T) {
+	a := func(name string, last byte) []dnsmessage.Resource {
+		return []dnsmessage.Resource{dnstest.RR(name, &dnsmessage.AResource{A: [4]byte{192, 0, 2, last}})}
+	}
+	recursive := (&dnstest.Server{Records: map[dnstest.Key][]dnsmessage.Resource{
+		{Name: "test.", Type: dnsmessage.TypeNS}:         nsSet("test.", "ns.tld.test."),
+		{Name: "corp.test.", Type: dnsmessage.TypeNS}:    nsSet("corp.test.", "ns1.corp.test.", "ns2.corp.test.", "ns3.corp.test."),
+		{Name: "ns.tld.test.", Type: dnsmessage.TypeA}:   a("ns.tld.test.", 1),
+		{Name: "ns1.corp.test.", Type: dnsmessage.TypeA}: a("ns1.corp.test.", 11),
+		{Name: "ns2.corp.test.", Type: dnsmessage.TypeA}: a("ns2.corp.test.", 12),
+		{Name: "ns3.corp.test.", Type: dnsmessage.TypeA}: a("ns3.corp.test.", 13),
+		{Name: "ns4.corp.test.", Type: dnsmessage.TypeA}: a("ns4.corp.test.", 14),
+	}}).Start(t)
+	parent := (&dnstest.Server{Authority: map[dnstest.Key][]dnsmessage.Resource{
+		{Name: "corp.test.", Type: dnsmessage.TypeNS}: nsSet("corp.test.", "ns1.corp.test.", "ns2.corp.test.", "ns4.corp.test."),
+	}}).Start(t)
+	servers := map[string]string{
+		"192.0.2.1:53":  parent,
+		"192.0.2.11:53": authZone(7, "ns1.corp.test.", "ns2.corp.test.", "ns4.corp.test.").Start(t),
+		"192.0.2.12:53": authZone(5, "ns1.corp.test.", "ns2.corp.test.", "ns3.corp.test.").Start(t),
+		"192.0.2.13:53": (&dnstest.Server{Records: map[dnstest.Key][]dnsmessage.Resource{}}).Start(t),
+	}
+
+	r := NewResolver([]string{recursive})
+	var d net.Dialer
+	r.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "192.0.2.14:53" {
+			return nil, errors.New("connection refused")
+		}
+		if s, ok := servers[addr]; ok {
+			addr = s
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+
+	del := r.CheckDelegation(context.Background(), "corp.test")
+	if got := strings.Join(del.Parent, ","); got != "ns1.corp.test,ns2.corp.test,ns4.corp.test" {
+		t.Errorf("expected parent NS set from the referral, got %s", got)
+	}
+	status := map[string]string{}
+	for _, s := range del.Servers {
+		status[s.NS] = s.Status
+	}
+	want := map[string]string{"ns1.corp.test": NSHealthy, "ns2.corp.test": NSHealthy, "ns3.corp.test": NSLame, "ns4.corp.test": NSUnreachable}
+	for ns, st := range want {
+		if status[ns] != st {
+			t.Errorf("expected %s to be %s, got %q", ns, st, status[ns])
+		}
+	}
+
+	got := map[string]bool{}
+	for _, i := range del.Issues() {
+		got[i.Kind+" "+i.NS] = true
+	}
+	for _, w := range []string{
+		IssueLame + " ns3.corp.test",
+		IssueLame + " ns4.corp.test",
+		IssueNSMismatch + " ns2.corp.test",
+		IssueSerialDrift + " ns2.corp.test",
+	} {
+		if !got[w] {
+			t.Errorf("expected issue %q, got %v", w, got)
+		}
+	}
+	if len(got) != 4 {
+		t.Errorf("expected 4 issues, got %v", got)
+	}
+}
+
+func TestResolver_CheckDelegation_IPv6ParentAndTimeout(t *testing.T) {
+	recursive := (&dnstest.Server{Records: map[dnstest.Key][]dnsmessage.Resource{
+		{Name: "test.", Type: dnsmessage.TypeNS}:          nsSet("test.", "ns.tld.test."),
+		{Name: "corp.test.", Type: dnsmessage.TypeNS}:     nsSet("corp.test.", "ns1.corp.test.", "ns2.corp.test."),
+		{Name: "ns.tld.test.", Type: dnsmessage.TypeAAAA}: {dnstest.RR("ns.tld.test.", &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}})},
+		{Name: "ns1.corp.test.", Type: dnsmessage.TypeA}:  {dnstest.RR("ns1.corp.test.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 11}})},
+		{Name: "ns2.corp.test.", Type: dnsmessage.TypeA}:  {dnstest.RR("ns2.corp.test.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 12}})},
+	}}).Start(t)
+	parent := (&dnstest.Server{Authority: map[dnstest.Key][]dnsmessage.Resource{
+		{Name: "corp.test.", Type: dnsmessage.TypeNS}: nsSet("corp.test.", "ns1.corp.test.", "ns2.corp.test."),
+	}}).Start(t)
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close() // reads queries, never answers
+	servers := map[string]string{
+		"[2001:db8::1]:53": parent,
+		"192.0.2.11:53":    authZone(7, "ns1.corp.test.", "ns2.corp.test.").Start(t),
+		"192.0.2.12:53":    silent.LocalAddr().String(),
+	}
+
+	r := NewResolver([]string{recursive})
+	r.Timeout = 100 * time.Millisecond
+	var d net.Dialer
+	r.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if s, ok := servers[addr]; ok {
+			addr = s
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+
+	del := r.CheckDelegation(context.Background(), "corp.test")
+	if got := strings.Join(del.Parent, ","); got != "ns1.corp.test,ns2.corp.test" {
+		t.Errorf("expected the referral from the IPv6-only parent server, got %q", got)
+	}
+	for _, s := range del.Servers {
+		if s.NS == "ns2.corp.test" && s.Status != NSTimeout {
+			t.Errorf("expected ns2 to have timed out, got %s", s.Status)
+		}
+	}
+	if issues := del.Issues(); len(issues) != 0 {
+		t.Errorf("expected a timeout not to be reported as lame, got %+v", issues)
+	}
+}
@@ -2,26 +2,18 @@ package dns
 
 import (
 	"context"
-	"net"
-	"strings"
 )
 
+// ResolveAll looks host up with the system's name servers and returns its
+// addresses, NS, final CNAME target (empty if host is not an alias), MX and
+// TXT records. It wraps Resolver.Lookup for callers that want the records
+// without a Resolver; failed queries just leave their slice empty.
 func ResolveAll(ctx context.Context, host string) (ips []string, nsHosts []string, cname string, mxHosts []string, txts []string) {
-	ips, nsHosts, mxHosts, txts = []string{}, []string{}, []string{}, []string{}
-	if iplist, err := net.DefaultResolver.LookupIP(ctx, "ip", host); err == nil {
-		for _, ip := range iplist { ips = append(ips, ip.String()) }
+	rec := NewResolver(nil).Lookup(ctx, host)
+	if n := len(rec.CNAMEs); n > 0 { cname = rec.CNAMEs[n-1] }
+	nonNil := func(s []string) []string {
+		if s == nil { return []string{} }
+		return s
 	}
-	if ns, err := net.DefaultResolver.LookupNS(ctx, host); err == nil {
-		for _, n := range ns { nsHosts = append(nsHosts, strings.TrimSuffix(n.Host, ".")) }
-	}
-	if c, err := net.DefaultResolver.LookupCNAME(ctx, host); err == nil {
-		cname = strings.TrimSuffix(c, ".")
-	}
-	if mxs, err := net.DefaultResolver.LookupMX(ctx, host); err == nil {
-		for _, m := range mxs { mxHosts = append(mxHosts, strings.TrimSuffix(m.Host, ".")) }
-	}
-	if t, err := net.DefaultResolver.LookupTXT(ctx, host); err == nil {
-		txts = append(txts, t...)
-	}
-	return
+	return nonNil(rec.IPs()), nonNil(rec.NS), cname, nonNil(rec.MX), nonNil(rec.TXT)
 }
@@ -0,0 +1,132 @@
+// Package dnstest provides an in-process DNS server for tests of the
+// resolver and the packages built on it.
+package dnstest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Key selects the records answering one question. Name is lower-case and
+// fully qualified.
+type Key struct {
+	Name string
+	Type dnsmessage.Type
+}
+
+// Server answers queries from Records over UDP and TCP on one port. Names
+// in Truncate get a truncated, empty UDP answer so clients must retry over
+// TCP. Any A query under a zone in Wildcard gets that address; any query
+// under a zone in ZoneRCodes that Records does not answer gets that rcode.
+// Names without records get an empty NOERROR (NODATA) unless RCodes says
+// otherwise.
+type Server struct {
+	Records    map[Key][]dnsmessage.Resource
+	RCodes     map[string]dnsmessage.RCode
+	ZoneRCodes map[string]dnsmessage.RCode
+	Truncate   map[string]bool
+	Wildcard   map[string][4]byte
+	// Authority fills the authority section; Auth sets the AA bit.
+	Authority map[Key][]dnsmessage.Resource
+	Auth      bool
+	// Queries counts the questions answered; read it with atomic.LoadInt32.
+	Queries int32
+}
+
+// Answer returns the packed response to req, or nil when req is not a
+// single-question query.
+func (s *Server) Answer(req []byte, udp bool) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(req); err != nil || len(msg.Questions) != 1 { return nil }
+	atomic.AddInt32(&s.Queries, 1)
+	q := msg.Questions[0]
+	name := strings.ToLower(q.Name.String())
+	resp := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: msg.Header.ID, Response: true, Authoritative: s.Auth, RecursionAvailable: !s.Auth, RCode: s.RCodes[name]},
+		Questions:   msg.Questions,
+		Authorities: clone(s.Authority[Key{name, q.Type}]),
+	}
+	for zone, rcode := range s.ZoneRCodes {
+		if strings.HasSuffix(name, "."+zone) && s.Records[Key{name, q.Type}] == nil { resp.Header.RCode = rcode }
+	}
+	if udp && s.Truncate[name] {
+		resp.Header.Truncated = true
+	} else {
+		resp.Answers = clone(s.Records[Key{name, q.Type}])
+		for zone, ip := range s.Wildcard {
+			if resp.Answers == nil && q.Type == dnsmessage.TypeA && strings.HasSuffix(name, "."+zone) {
+				resp.Answers = []dnsmessage.Resource{RR(name, &dnsmessage.AResource{A: ip})}
+			}
+		}
+	}
+	b, _ := resp.Pack()
+	return b
+}
+
+// clone copies rs so that packing, which fills in header lengths, does not
+// write to records shared by concurrent answers.
+func clone(rs []dnsmessage.Resource) []dnsmessage.Resource {
+	if rs == nil { return nil }
+	return append([]dnsmessage.Resource(nil), rs...)
+}
+
+// Start serves s on a loopback port until the test ends and returns its
+// address.
+func (s *Server) Start(t testing.TB) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { pc.Close() })
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil { return }
+			if b := s.Answer(buf[:n], true); b != nil { pc.WriteTo(b, addr) }
+		}
+	}()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil { return }
+			go func(c net.Conn) {
+				defer c.Close()
+				var n [2]byte
+				if _, err := io.ReadFull(c, n[:]); err != nil { return }
+				req := make([]byte, binary.BigEndian.Uint16(n[:]))
+				if _, err := io.ReadFull(c, req); err != nil { return }
+				b := s.Answer(req, false)
+				c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+			}(c)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// Dead returns a loopback address nothing listens on, so queries to it
+// fail at once.
+func Dead(t testing.TB) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	addr := pc.LocalAddr().String()
+	pc.Close()
+	return addr
+}
+
+// RR returns a resource record for name with a 300 second TTL.
+func RR(name string, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: 300},
+		Body:   body,
+	}
+}
@@ -0,0 +1,589 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Record types dnsmessage has no constants for.
+const (
+	TypeDS     dnsmessage.Type = 43
+	TypeDNSKEY dnsmessage.Type = 48
+	TypeCAA    dnsmessage.Type = 257
+)
+
+// DNSSEC statuses of a zone.
+const (
+	DNSSECSigned   = "signed"   // DS at the parent and DNSKEY in the zone
+	DNSSECUnsigned = "unsigned" // neither
+	DNSSECIsland   = "island"   // DNSKEY without a DS, so nothing chains to it
+	DNSSECBroken   = "broken"   // DS without a resolvable DNSKEY
+)
+
+// SRVServices are the service labels queried under every zone.
+var SRVServices = []string{
+	"_sip._tcp", "_sip._udp", "_sips._tcp",
+	"_xmpp-client._tcp", "_xmpp-server._tcp",
+	"_submission._tcp", "_imaps._tcp", "_pop3s._tcp",
+	"_autodiscover._tcp", "_caldavs._tcp", "_carddavs._tcp",
+	"_ldap._tcp", "_kerberos._udp", "_minecraft._tcp",
+}
+
+// Lookup outcomes, per record type and for a host as a whole.
+const (
+	StatusResolves = "resolves" // at least one record
+	StatusNoData   = "nodata"   // the name exists but has no such record
+	StatusNXDomain = "nxdomain"
+	StatusServFail = "servfail"
+	StatusRefused  = "refused"
+	StatusTimeout  = "timeout"
+	StatusError    = "error" // any other failure to get an answer
+)
+
+// Records holds the per-type answers for one host.
+type Records struct {
+	A    []string
+	AAAA []string
+	// CNAMEs is the alias chain from host, one entry per hop.
+	CNAMEs []string
+	NS     []string
+	MX     []string
+	TXT    []string
+	// Status is the outcome of each query, keyed by record type ("A",
+	// "AAAA", "NS", "MX", "TXT").
+	Status map[string]string
+}
+
+// HostStatus summarizes Status: resolves if any type answered, otherwise
+// the most telling failure, so a dead name can be told apart from a
+// resolver problem.
+func (r Records) HostStatus() string {
+	if len(r.Status) == 0 { return "" }
+	for _, s := range []string{StatusResolves, StatusNXDomain, StatusServFail, StatusRefused, StatusTimeout, StatusError} {
+		for _, v := range r.Status {
+			if v == s { return s }
+		}
+	}
+	return StatusNoData
+}
+
+// status classifies the outcome of one query.
+func status(ans *Answer, err error) string {
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()):
+		return StatusTimeout
+	case err != nil:
+		return StatusError
+	}
+	switch ans.RCode {
+	case dnsmessage.RCodeSuccess:
+		if len(ans.Resources) == 0 { return StatusNoData }
+		return StatusResolves
+	case dnsmessage.RCodeNameError:
+		return StatusNXDomain
+	case dnsmessage.RCodeServerFailure:
+		return StatusServFail
+	case dnsmessage.RCodeRefused:
+		return StatusRefused
+	}
+	return StatusError
+}
+
+// IPs returns the IPv4 then the IPv6 addresses.
+func (r Records) IPs() []string {
+	return append(append([]string{}, r.A...), r.AAAA...)
+}
+
+// Zone holds the records that describe a zone rather than a single host.
+type Zone struct {
+	SOA    *SOA
+	CAA    []CAA
+	SRV    []SRV
+	DNSSEC string
+}
+
+type SOA struct {
+	MName  string
+	RName  string
+	Serial uint32
+}
+
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// Issuer returns the CA domain an issue or issuewild property authorizes,
+// or "" for other tags and for the empty value that forbids issuance.
+func (c CAA) Issuer() string {
+	if c.Tag != "issue" && c.Tag != "issuewild" { return "" }
+	d, _, _ := strings.Cut(c.Value, ";")
+	return strings.ToLower(strings.TrimSpace(d))
+}
+
+type SRV struct {
+	Service  string
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// Resolver queries recursive name servers directly so that every record
+// type, not just those net.Resolver exposes, can be collected.
+type Resolver struct {
+	// Servers are tried in order: host:port for UDP with TCP fallback,
+	// tcp://host:port for TCP only, tls://host:port for DNS over TLS and
+	// https:// URLs for DNS over HTTPS.
+	Servers []string
+	// Timeout bounds each exchange with one server.
+	Timeout time.Duration
+	// Dial makes connections to the servers; nil dials directly.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// TLSConfig is used for DoT and DoH servers; nil uses the system roots.
+	TLSConfig *tls.Config
+	// Cache, when set, holds recursive answers for their TTL. Direct
+	// queries to authoritative servers are never cached.
+	Cache *Cache
+
+	mu        sync.Mutex
+	wildcards map[string]Wildcard
+	httpc     *http.Client
+}
+
+// NewResolver returns a Resolver for servers, given as host or host:port,
+// optionally prefixed with udp://, tcp:// or tls://, or as https:// DoH
+// URLs. Ports default to 53, or 853 for tls://. With no servers it uses
+// the name servers in /etc/resolv.conf.
+func NewResolver(servers []string) *Resolver {
+	r := &Resolver{Timeout: 3 * time.Second}
+	for _, s := range servers {
+		if s = strings.TrimSpace(s); s == "" { continue }
+		r.Servers = append(r.Servers, normalizeServer(s))
+	}
+	if len(r.Servers) == 0 { r.Servers = systemServers("/etc/resolv.conf") }
+	return r
+}
+
+// systemServers reads the nameserver lines of a resolv.conf file, falling
+// back to a local resolver like the Go and libc resolvers do.
+func systemServers(path string) []string {
+	var out []string
+	if f, err := os.Open(path); err == nil {
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" { out = append(out, net.JoinHostPort(fields[1], "53")) }
+		}
+	}
+	if len(out) == 0 { out = []string{"127.0.0.1:53", "[::1]:53"} }
+	return out
+}
+
+// Answer is one response to a query.
+type Answer struct {
+	RCode     dnsmessage.RCode
+	Resources []dnsmessage.Resource
+
+	authorities []dnsmessage.Resource
+}
+
+// Query asks the configured servers for name/qtype in turn and returns the
+// first response, whatever its rcode. Truncated UDP answers are retried
+// over TCP. With a Cache set, answers are served from it while their TTL
+// lasts and concurrent identical queries share one exchange; callers must
+// not modify the returned Answer.
+func (r *Resolver) Query(ctx context.Context, name string, qtype dnsmessage.Type) (*Answer, error) {
+	if r.Cache != nil { return r.Cache.lookup(ctx, name, qtype, r.flightTimeout(), r.query) }
+	return r.query(ctx, name, qtype)
+}
+
+// flightTimeout bounds one cached query shared by several callers: every
+// server may take the full exchange timeout, twice when a truncated answer
+// is retried over TCP.
+func (r *Resolver) flightTimeout() time.Duration {
+	timeout := r.Timeout
+	if timeout == 0 { timeout = 3 * time.Second }
+	return 2 * timeout * time.Duration(max(len(r.Servers), 1))
+}
+
+func (r *Resolver) query(ctx context.Context, name string, qtype dnsmessage.Type) (*Answer, error) {
+	q, err := dnsmessage.NewName(fqdn(name))
+	if err != nil { return nil, err }
+	err = errors.New("dns: no servers configured")
+	// A SERVFAIL or REFUSED from one server says nothing about the name, so
+	// the next server is tried; the last such answer is kept in case none
+	// of them does better.
+	var failed *Answer
+	for _, server := range r.Servers {
+		if ctx.Err() != nil { return nil, ctx.Err() }
+		msg, xerr := r.exchange(ctx, server, q, qtype, true)
+		if xerr != nil { err = xerr; continue }
+		a := &Answer{RCode: msg.Header.RCode, Resources: msg.Answers, authorities: msg.Authorities}
+		if a.RCode == dnsmessage.RCodeServerFailure || a.RCode == dnsmessage.RCodeRefused { failed = a; continue }
+		return a, nil
+	}
+	if failed != nil { return failed, nil }
+	return nil, err
+}
+
+func (r *Resolver) exchange(ctx context.Context, server string, q dnsmessage.Name, qtype dnsmessage.Type, recurse bool) (*dnsmessage.Message, error) {
+	id := queryID()
+	req := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: recurse},
+		Questions: []dnsmessage.Question{{Name: q, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false); err != nil { return nil, err }
+	req.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}}
+	b, err := req.Pack()
+	if err != nil { return nil, err }
+	msg, err := r.send(ctx, server, b)
+	if err != nil { return nil, err }
+	if msg.Header.ID != id || len(msg.Questions) != 1 || !strings.EqualFold(msg.Questions[0].Name.String(), q.String()) {
+		return nil, fmt.Errorf("dns: mismatched response from %s", server)
+	}
+	return msg, nil
+}
+
+func (r *Resolver) roundTrip(ctx context.Context, network, server string, b []byte) (*dnsmessage.Message, error) {
+	timeout := r.Timeout
+	if timeout == 0 { timeout = 3 * time.Second }
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dial := r.Dial
+	if dial == nil { dial = (&net.Dialer{}).DialContext }
+	conn, err := dial(ctx, strings.Replace(network, "tls", "tcp", 1), server)
+	if err != nil { return nil, err }
+	if network == "tls" { conn = tls.Client(conn, r.tlsConfig(server)) }
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok { conn.SetDeadline(dl) }
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	var resp []byte
+	if network != "udp" {
+		framed := binary.BigEndian.AppendUint16(make([]byte, 0, len(b)+2), uint16(len(b)))
+		if _, err := conn.Write(append(framed, b...)); err != nil { return nil, err }
+		var n [2]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil { return nil, err }
+		resp = make([]byte, binary.BigEndian.Uint16(n[:]))
+		if _, err := io.ReadFull(conn, resp); err != nil { return nil, err }
+	} else {
+		if _, err := conn.Write(b); err != nil { return nil, err }
+		resp = make([]byte, 65535)
+		n, err := conn.Read(resp)
+		if err != nil { return nil, err }
+		resp = resp[:n]
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil { return nil, err }
+	return &msg, nil
+}
+
+// hostTypes are the per-host record types Lookup queries.
+var hostTypes = []struct {
+	name  string
+	qtype dnsmessage.Type
+}{
+	{"A", dnsmessage.TypeA}, {"AAAA", dnsmessage.TypeAAAA}, {"NS", dnsmessage.TypeNS},
+	{"MX", dnsmessage.TypeMX}, {"TXT", dnsmessage.TypeTXT},
+}
+
+// Lookup collects the A, AAAA, CNAME, NS, MX and TXT records of host and
+// the outcome of each query.
+func (r *Resolver) Lookup(ctx context.Context, host string) Records {
+	rec := Records{Status: make(map[string]string, len(hostTypes))}
+	for _, ht := range hostTypes {
+		ans, err := r.Query(ctx, host, ht.qtype)
+		st := status(ans, err)
+		if st == StatusResolves && !hasType(ans, ht.qtype) { st = StatusNoData }
+		rec.Status[ht.name] = st
+		if err != nil || ans.RCode != dnsmessage.RCodeSuccess { continue }
+		for _, res := range ans.Resources {
+			switch b := res.Body.(type) {
+			case *dnsmessage.AResource:
+				rec.A = appendUnique(rec.A, net.IP(b.A[:]).String())
+			case *dnsmessage.AAAAResource:
+				rec.AAAA = appendUnique(rec.AAAA, net.IP(b.AAAA[:]).String())
+			case *dnsmessage.NSResource:
+				rec.NS = appendUnique(rec.NS, trimName(b.NS))
+			case *dnsmessage.MXResource:
+				rec.MX = appendUnique(rec.MX, trimName(b.MX))
+			case *dnsmessage.TXTResource:
+				rec.TXT = append(rec.TXT, strings.Join(b.TXT, ""))
+			}
+		}
+	}
+	rec.CNAMEs, _ = r.CNAMEChain(ctx, host)
+	return rec
+}
+
+// MaxCNAMEHops bounds CNAMEChain; resolvers give up at similar depths.
+const MaxCNAMEHops = 16
+
+// ErrCNAMELoop is returned when a chain revisits a name.
+var ErrCNAMELoop = errors.New("dns: cname loop")
+
+// CNAMEChain follows CNAME records from host one query per hop and returns
+// each target in order. On a loop the chain ends with the name that closes
+// it and ErrCNAMELoop is returned alongside.
+func (r *Resolver) CNAMEChain(ctx context.Context, host string) ([]string, error) {
+	var chain []string
+	seen := map[string]bool{strings.ToLower(host): true}
+	for name := strings.ToLower(host); len(chain) < MaxCNAMEHops; {
+		ans, err := r.Query(ctx, name, dnsmessage.TypeCNAME)
+		if err != nil { return chain, err }
+		next := ""
+		for _, res := range ans.Resources {
+			if b, ok := res.Body.(*dnsmessage.CNAMEResource); ok && trimName(res.Header.Name) == name {
+				next = trimName(b.CNAME)
+				break
+			}
+		}
+		if next == "" { return chain, nil }
+		chain = append(chain, next)
+		if seen[next] { return chain, ErrCNAMELoop }
+		seen[next] = true
+		name = next
+	}
+	return chain, fmt.Errorf("dns: cname chain longer than %d hops", MaxCNAMEHops)
+}
+
+// LookupZone collects the SOA, CAA and common SRV records of the zone at
+// apex and its DNSSEC status.
+func (r *Resolver) LookupZone(ctx context.Context, apex string) Zone {
+	var z Zone
+	if ans, err := r.Query(ctx, apex, dnsmessage.TypeSOA); err == nil {
+		for _, res := range ans.Resources {
+			if b, ok := res.Body.(*dnsmessage.SOAResource); ok {
+				z.SOA = &SOA{MName: trimName(b.NS), RName: trimName(b.MBox), Serial: b.Serial}
+				break
+			}
+		}
+	}
+	if ans, err := r.Query(ctx, apex, TypeCAA); err == nil {
+		for _, res := range ans.Resources {
+			if c, ok := parseCAA(res); ok { z.CAA = append(z.CAA, c) }
+		}
+	}
+	for _, svc := range SRVServices {
+		ans, err := r.Query(ctx, svc+"."+apex, dnsmessage.TypeSRV)
+		if err != nil { continue }
+		for _, res := range ans.Resources {
+			if b, ok := res.Body.(*dnsmessage.SRVResource); ok {
+				z.SRV = append(z.SRV, SRV{Service: svc, Target: trimName(b.Target), Port: b.Port, Priority: b.Priority, Weight: b.Weight})
+			}
+		}
+	}
+	ds := r.has(ctx, apex, TypeDS)
+	key := r.has(ctx, apex, TypeDNSKEY)
+	switch {
+	case ds && key:
+		z.DNSSEC = DNSSECSigned
+	case ds:
+		z.DNSSEC = DNSSECBroken
+	case key:
+		z.DNSSEC = DNSSECIsland
+	default:
+		z.DNSSEC = DNSSECUnsigned
+	}
+	return z
+}
+
+// LookupNS returns the name servers of zone.
+func (r *Resolver) LookupNS(ctx context.Context, zone string) []string {
+	ans, err := r.Query(ctx, zone, dnsmessage.TypeNS)
+	if err != nil { return nil }
+	var out []string
+	for _, res := range ans.Resources {
+		if b, ok := res.Body.(*dnsmessage.NSResource); ok { out = appendUnique(out, trimName(b.NS)) }
+	}
+	return out
+}
+
+// LookupAddrs returns the A (or, with ipv6, AAAA) addresses of host,
+// following any CNAME the server resolved, and the query's status.
+func (r *Resolver) LookupAddrs(ctx context.Context, host string, ipv6 bool) ([]string, string) {
+	qt := dnsmessage.TypeA
+	if ipv6 { qt = dnsmessage.TypeAAAA }
+	ans, err := r.Query(ctx, host, qt)
+	st := status(ans, err)
+	if err != nil { return nil, st }
+	var ips []string
+	for _, res := range ans.Resources {
+		switch b := res.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = appendUnique(ips, net.IP(b.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			ips = appendUnique(ips, net.IP(b.AAAA[:]).String())
+		}
+	}
+	if st == StatusResolves && len(ips) == 0 { st = StatusNoData }
+	return ips, st
+}
+
+// Wildcard is what a zone answers for names that do not exist. Known is
+// false when the probes got no definitive answer, in which case Enabled
+// and IPs say nothing about the zone.
+type Wildcard struct {
+	Known   bool
+	Enabled bool
+	IPs     []string
+}
+
+// Matches reports whether ip is one of the wildcard answers.
+func (w Wildcard) Matches(ip string) bool {
+	for _, x := range w.IPs {
+		if x == ip { return true }
+	}
+	return false
+}
+
+// wildcardProbes is how many random labels are tried per zone; a wildcard
+// answers all of them, a real name almost never collides with one.
+const wildcardProbes = 2
+
+// Wildcard reports whether apex answers random labels beneath it and with
+// which addresses. The result is cached per apex for the Resolver's
+// lifetime, but only when every probe got an answer, NXDOMAIN or NODATA:
+// a timeout, SERVFAIL or REFUSED says nothing about the zone, so the
+// result is not Known and the next call probes again.
+func (r *Resolver) Wildcard(ctx context.Context, apex string) Wildcard {
+	apex = strings.ToLower(apex)
+	r.mu.Lock()
+	w, ok := r.wildcards[apex]
+	r.mu.Unlock()
+	if ok { return w }
+
+	for i := 0; i < wildcardProbes; i++ {
+		name := randomLabel() + "." + apex
+		v4, s4 := r.LookupAddrs(ctx, name, false)
+		v6, s6 := r.LookupAddrs(ctx, name, true)
+		if len(v4)+len(v6) == 0 {
+			if !definitive(s4) || !definitive(s6) { return Wildcard{} }
+			w = Wildcard{}
+			break
+		}
+		w.Enabled = true
+		for _, ip := range append(v4, v6...) { w.IPs = appendUnique(w.IPs, ip) }
+	}
+
+	w.Known = true
+	r.mu.Lock()
+	if r.wildcards == nil { r.wildcards = make(map[string]Wildcard) }
+	r.wildcards[apex] = w
+	r.mu.Unlock()
+	return w
+}
+
+// definitive reports whether a query status describes the name rather
+// than the path to it.
+func definitive(st string) bool {
+	return st == StatusResolves || st == StatusNoData || st == StatusNXDomain
+}
+
+func randomLabel() string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	for i := range b { b[i] = alphabet[int(b[i])%len(alphabet)] }
+	return string(b)
+}
+
+// queryID returns an unpredictable message ID; a guessable one makes
+// off-path spoofing of UDP answers trivial.
+func queryID() uint16 {
+	var b [2]byte
+	_, _ = rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// LookupPTR returns the names ip reverse-resolves to.
+func (r *Resolver) LookupPTR(ctx context.Context, ip string) ([]string, error) {
+	arpa, err := reverseName(ip)
+	if err != nil { return nil, err }
+	ans, err := r.Query(ctx, arpa, dnsmessage.TypePTR)
+	if err != nil { return nil, err }
+	var names []string
+	for _, res := range ans.Resources {
+		if b, ok := res.Body.(*dnsmessage.PTRResource); ok { names = appendUnique(names, trimName(b.PTR)) }
+	}
+	return names, nil
+}
+
+// has reports whether name has at least one record of qtype.
+func (r *Resolver) has(ctx context.Context, name string, qtype dnsmessage.Type) bool {
+	ans, err := r.Query(ctx, name, qtype)
+	return err == nil && hasType(ans, qtype)
+}
+
+// hasType reports whether ans holds a record of qtype, not just a CNAME
+// pointing elsewhere.
+func hasType(ans *Answer, qtype dnsmessage.Type) bool {
+	for _, res := range ans.Resources {
+		if res.Header.Type == qtype { return true }
+	}
+	return false
+}
+
+// parseCAA decodes the RFC 8659 wire format: flags, tag length, tag, value.
+func parseCAA(res dnsmessage.Resource) (CAA, bool) {
+	u, ok := res.Body.(*dnsmessage.UnknownResource)
+	if !ok || res.Header.Type != TypeCAA || len(u.Data) < 2 { return CAA{}, false }
+	n := int(u.Data[1])
+	if len(u.Data) < 2+n { return CAA{}, false }
+	return CAA{Flag: u.Data[0], Tag: strings.ToLower(string(u.Data[2 : 2+n])), Value: string(u.Data[2+n:])}, true
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name for ip.
+func reverseName(ip string) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil { return "", fmt.Errorf("dns: invalid ip %q", ip) }
+	var b strings.Builder
+	if v4 := addr.To4(); v4 != nil {
+		for i := 3; i >= 0; i-- { fmt.Fprintf(&b, "%d.", v4[i]) }
+		return b.String() + "in-addr.arpa", nil
+	}
+	const hex = "0123456789abcdef"
+	for i := len(addr) - 1; i >= 0; i-- {
+		b.WriteByte(hex[addr[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hex[addr[i]>>4])
+		b.WriteByte('.')
+	}
+	return b.String() + "ip6.arpa", nil
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") { return name }
+	return name + "."
+}
+
+func trimName(n dnsmessage.Name) string {
+	return strings.ToLower(strings.TrimSuffix(n.String(), "."))
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, x := range list {
+		if x == v { return list }
+	}
+	return append(list, v)
+}
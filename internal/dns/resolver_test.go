@@ -0,0 +1,313 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gustycube/spyder/internal/dns/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func caa(name, tag, value string) dnsmessage.Resource {
+	data := append([]byte{0, byte(len(tag))}, tag+value...)
+	return dnstest.RR(name, &dnsmessage.UnknownResource{Type: TypeCAA, Data: data})
+}
+
+func exampleZone() *dnstest.Server {
+	n := dnsmessage.MustNewName
+	return &dnstest.Server{
+		Records: map[dnstest.Key][]dnsmessage.Resource{
+			{Name: "www.example.com.", Type: dnsmessage.TypeA}: {
+				dnstest.RR("www.example.com.", &dnsmessage.CNAMEResource{CNAME: n("edge.cdn.net.")}),
+				dnstest.RR("edge.cdn.net.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 10}}),
+			},
+			{Name: "www.example.com.", Type: dnsmessage.TypeAAAA}: {
+				dnstest.RR("www.example.com.", &dnsmessage.CNAMEResource{CNAME: n("edge.cdn.net.")}),
+				dnstest.RR("edge.cdn.net.", &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}),
+			},
+			{Name: "www.example.com.", Type: dnsmessage.TypeCNAME}: {
+				dnstest.RR("www.example.com.", &dnsmessage.CNAMEResource{CNAME: n("edge.cdn.net.")}),
+			},
+			{Name: "example.com.", Type: dnsmessage.TypeSOA}: {
+				dnstest.RR("example.com.", &dnsmessage.SOAResource{NS: n("ns1.example.com."), MBox: n("hostmaster.example.com."), Serial: 2024010101}),
+			},
+			{Name: "example.com.", Type: dnsmessage.TypeMX}: {
+				dnstest.RR("example.com.", &dnsmessage.MXResource{Pref: 10, MX: n("mx1.example.com.")}),
+			},
+			{Name: "example.com.", Type: TypeCAA}: {
+				caa("example.com.", "issue", "letsencrypt.org; accounturi=https://acme/1"),
+				caa("example.com.", "issuewild", ";"),
+				caa("example.com.", "iodef", "mailto:sec@example.com"),
+			},
+			{Name: "_sip._tcp.example.com.", Type: dnsmessage.TypeSRV}: {
+				dnstest.RR("_sip._tcp.example.com.", &dnsmessage.SRVResource{Priority: 10, Weight: 5, Port: 5060, Target: n("sip.example.com.")}),
+			},
+			{Name: "example.com.", Type: TypeDS}: {
+				dnstest.RR("example.com.", &dnsmessage.UnknownResource{Type: TypeDS, Data: []byte{1}}),
+			},
+			{Name: "example.com.", Type: TypeDNSKEY}: {
+				dnstest.RR("example.com.", &dnsmessage.UnknownResource{Type: TypeDNSKEY, Data: []byte{1}}),
+			},
+			{Name: "10.2.0.192.in-addr.arpa.", Type: dnsmessage.TypePTR}: {
+				dnstest.RR("10.2.0.192.in-addr.arpa.", &dnsmessage.PTRResource{PTR: n("edge-10.cdn.net.")}),
+			},
+		},
+		Truncate: map[string]bool{"example.com.": true},
+	}
+}
+
+func TestResolver_Lookup(t *testing.T) {
+	r := NewResolver([]string{exampleZone().Start(t)})
+
+	rec := r.Lookup(context.Background(), "www.example.com")
+	if len(rec.A) != 1 || rec.A[0] != "192.0.2.10" {
+		t.Errorf("expected A 192.0.2.10, got %v", rec.A)
+	}
+	if len(rec.AAAA) != 1 || rec.AAAA[0] != "2001:db8::1" {
+		t.Errorf("expected AAAA 2001:db8::1, got %v", rec.AAAA)
+	}
+	if len(rec.CNAMEs) != 1 || rec.CNAMEs[0] != "edge.cdn.net" {
+		t.Errorf("expected CNAME edge.cdn.net, got %v", rec.CNAMEs)
+	}
+	if ips := rec.IPs(); len(ips) != 2 || ips[0] != "192.0.2.10" {
+		t.Errorf("expected IPv4 before IPv6, got %v", ips)
+	}
+
+	// answers for example.com only arrive over TCP
+	if rec := r.Lookup(context.Background(), "example.com"); len(rec.MX) != 1 || rec.MX[0] != "mx1.example.com" {
+		t.Errorf("expected MX via TCP fallback, got %v", rec.MX)
+	}
+}
+
+func TestResolver_LookupZone(t *testing.T) {
+	r := NewResolver([]string{exampleZone().Start(t)})
+
+	z := r.LookupZone(context.Background(), "example.com")
+	if z.SOA == nil || z.SOA.MName != "ns1.example.com" || z.SOA.Serial != 2024010101 {
+		t.Errorf("expected SOA from ns1.example.com, got %+v", z.SOA)
+	}
+	var issuers []string
+	for _, c := range z.CAA {
+		if is := c.Issuer(); is != "" {
+			issuers = append(issuers, is)
+		}
+	}
+	if len(z.CAA) != 3 || len(issuers) != 1 || issuers[0] != "letsencrypt.org" {
+		t.Errorf("expected only letsencrypt.org authorized, got %+v", z.CAA)
+	}
+	if len(z.SRV) != 1 || z.SRV[0].Service != "_sip._tcp" || z.SRV[0].Target != "sip.example.com" || z.SRV[0].Port != 5060 {
+		t.Errorf("expected _sip._tcp SRV, got %+v", z.SRV)
+	}
+	if z.DNSSEC != DNSSECSigned {
+		t.Errorf("expected %s, got %s", DNSSECSigned, z.DNSSEC)
+	}
+	if other := r.LookupZone(context.Background(), "unsigned.test"); other.DNSSEC != DNSSECUnsigned {
+		t.Errorf("expected %s, got %s", DNSSECUnsigned, other.DNSSEC)
+	}
+}
+
+func TestResolver_LookupPTR(t *testing.T) {
+	r := NewResolver([]string{exampleZone().Start(t)})
+
+	names, err := r.LookupPTR(context.Background(), "192.0.2.10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 1 || names[0] != "edge-10.cdn.net" {
+		t.Errorf("expected edge-10.cdn.net, got %v", names)
+	}
+	if _, err := r.LookupPTR(context.Background(), "nope"); err == nil {
+		t.Error("expected an error for an invalid IP")
+	}
+}
+
+func TestResolver_FailsOver(t *testing.T) {
+	r := NewResolver([]string{dnstest.Dead(t), exampleZone().Start(t)})
+	if rec := r.Lookup(context.Background(), "www.example.com"); len(rec.A) != 1 {
+		t.Errorf("expected the second server to answer, got %+v", rec)
+	}
+}
+
+func TestResolver_FailsOverOnServFail(t *testing.T) {
+	bad := exampleZone()
+	bad.RCodes = map[string]dnsmessage.RCode{"www.example.com.": dnsmessage.RCodeServerFailure}
+	refused := exampleZone()
+	refused.RCodes = map[string]dnsmessage.RCode{"www.example.com.": dnsmessage.RCodeRefused}
+
+	r := NewResolver([]string{bad.Start(t), refused.Start(t), exampleZone().Start(t)})
+	if rec := r.Lookup(context.Background(), "www.example.com"); len(rec.A) != 1 || rec.Status["A"] != StatusResolves {
+		t.Errorf("expected the third server to answer, got %+v", rec)
+	}
+
+	r = NewResolver([]string{bad.Start(t), refused.Start(t)})
+	if rec := r.Lookup(context.Background(), "www.example.com"); rec.Status["A"] != StatusRefused {
+		t.Errorf("expected the last failure to be kept, got %v", rec.Status)
+	}
+}
+
+func TestResolver_LookupStatus(t *testing.T) {
+	f := exampleZone()
+	f.RCodes = map[string]dnsmessage.RCode{
+		"gone.example.com.":   dnsmessage.RCodeNameError,
+		"broken.example.com.": dnsmessage.RCodeServerFailure,
+	}
+	r := NewResolver([]string{f.Start(t)})
+
+	rec := r.Lookup(context.Background(), "www.example.com")
+	if rec.Status["A"] != StatusResolves || rec.Status["NS"] != StatusNoData {
+		t.Errorf("expected A resolves and NS nodata, got %v", rec.Status)
+	}
+	if rec.HostStatus() != StatusResolves {
+		t.Errorf("expected host status %s, got %s", StatusResolves, rec.HostStatus())
+	}
+	tests := map[string]string{
+		"gone.example.com":   StatusNXDomain,
+		"broken.example.com": StatusServFail,
+		"empty.example.com":  StatusNoData,
+	}
+	for host, want := range tests {
+		rec := r.Lookup(context.Background(), host)
+		if got := rec.HostStatus(); got != want {
+			t.Errorf("%s: expected %s, got %s (%v)", host, want, got, rec.Status)
+		}
+		if got := rec.Status["A"]; got != want {
+			t.Errorf("%s: expected A %s, got %s", host, want, got)
+		}
+	}
+}
+
+func TestResolver_LookupTimeout(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close() // reads queries, never answers
+
+	r := NewResolver([]string{pc.LocalAddr().String()})
+	r.Timeout = 50 * time.Millisecond
+	rec := r.Lookup(context.Background(), "slow.example.com")
+	if rec.HostStatus() != StatusTimeout || rec.Status["MX"] != StatusTimeout {
+		t.Errorf("expected timeouts, got %v", rec.Status)
+	}
+}
+
+func TestResolver_Wildcard(t *testing.T) {
+	f := exampleZone()
+	f.Wildcard = map[string][4]byte{"wild.test.": {198, 51, 100, 7}}
+	r := NewResolver([]string{f.Start(t)})
+
+	w := r.Wildcard(context.Background(), "wild.test")
+	if !w.Known || !w.Enabled || !w.Matches("198.51.100.7") || w.Matches("192.0.2.10") {
+		t.Errorf("expected wildcard answering 198.51.100.7, got %+v", w)
+	}
+	before := atomic.LoadInt32(&f.Queries)
+	if again := r.Wildcard(context.Background(), "WILD.test"); !again.Enabled {
+		t.Errorf("expected cached wildcard, got %+v", again)
+	}
+	if n := atomic.LoadInt32(&f.Queries) - before; n != 0 {
+		t.Errorf("expected the wildcard to be cached per apex, got %d more queries", n)
+	}
+
+	if w := r.Wildcard(context.Background(), "example.com"); !w.Known || w.Enabled {
+		t.Errorf("expected example.com known not to be a wildcard, got %+v", w)
+	}
+}
+
+func TestResolver_WildcardNotCachedOnFailure(t *testing.T) {
+	f := exampleZone()
+	f.ZoneRCodes = map[string]dnsmessage.RCode{"flaky.test.": dnsmessage.RCodeServerFailure}
+	r := NewResolver([]string{f.Start(t)})
+
+	if w := r.Wildcard(context.Background(), "flaky.test"); w.Known || w.Enabled {
+		t.Errorf("expected an unknown wildcard from failing probes, got %+v", w)
+	}
+	before := atomic.LoadInt32(&f.Queries)
+	r.Wildcard(context.Background(), "flaky.test")
+	if atomic.LoadInt32(&f.Queries) == before {
+		t.Error("expected a SERVFAIL result to be probed again")
+	}
+
+	before = atomic.LoadInt32(&f.Queries)
+	r.Wildcard(context.Background(), "example.com")
+	r.Wildcard(context.Background(), "example.com")
+	if n := atomic.LoadInt32(&f.Queries) - before; n != 2 {
+		t.Errorf("expected NXDOMAIN probes to be cached after one round, got %d queries", n)
+	}
+}
+
+func cnames(pairs ...string) *dnstest.Server {
+	f := &dnstest.Server{Records: map[dnstest.Key][]dnsmessage.Resource{}}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		f.Records[dnstest.Key{Name: pairs[i] + ".", Type: dnsmessage.TypeCNAME}] = []dnsmessage.Resource{
+			dnstest.RR(pairs[i]+".", &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(pairs[i+1] + ".")}),
+		}
+	}
+	return f
+}
+
+func TestResolver_CNAMEChain(t *testing.T) {
+	r := NewResolver([]string{cnames(
+		"www.shop.com", "shop.com.cdn.net",
+		"shop.com.cdn.net", "x.edge.net",
+		"x.edge.net", "pop1.edge.net",
+	).Start(t)})
+
+	chain, err := r.CNAMEChain(context.Background(), "WWW.shop.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"shop.com.cdn.net", "x.edge.net", "pop1.edge.net"}
+	if strings.Join(chain, " ") != strings.Join(want, " ") {
+		t.Errorf("expected chain %v, got %v", want, chain)
+	}
+
+	if chain, _ := r.CNAMEChain(context.Background(), "pop1.edge.net"); len(chain) != 0 {
+		t.Errorf("expected no chain for a terminal name, got %v", chain)
+	}
+}
+
+func TestResolver_CNAMELoop(t *testing.T) {
+	r := NewResolver([]string{cnames(
+		"a.loop.test", "b.loop.test",
+		"b.loop.test", "c.loop.test",
+		"c.loop.test", "a.loop.test",
+	).Start(t)})
+
+	chain, err := r.CNAMEChain(context.Background(), "a.loop.test")
+	if err != ErrCNAMELoop {
+		t.Fatalf("expected ErrCNAMELoop, got %v", err)
+	}
+	if len(chain) != 3 || chain[2] != "a.loop.test" {
+		t.Errorf("expected the chain to end where the loop closes, got %v", chain)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.10":  "10.2.0.192.in-addr.arpa",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+	}
+	for ip, want := range tests {
+		if got, _ := reverseName(ip); got != want {
+			t.Errorf("reverseName(%s) = %s, want %s", ip, got, want)
+		}
+	}
+}
+
+func TestSystemServers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(path, []byte("# comment\nsearch example.com\nnameserver 10.0.0.1\nnameserver 2001:db8::53\n"), 0o644)
+	got := systemServers(path)
+	if len(got) != 2 || got[0] != "10.0.0.1:53" || got[1] != "[2001:db8::53]:53" {
+		t.Errorf("expected both nameservers with port 53, got %v", got)
+	}
+	if got := systemServers(filepath.Join(t.TempDir(), "missing")); len(got) == 0 {
+		t.Error("expected a local fallback when resolv.conf is missing")
+	}
+}
@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohMediaType is the RFC 8484 wire format content type.
+const dohMediaType = "application/dns-message"
+
+// normalizeServer fills in the default port of a server address, keeping
+// any transport prefix.
+func normalizeServer(s string) string {
+	if strings.HasPrefix(s, "https://") { return s }
+	scheme, port := "", "53"
+	for _, p := range []string{"udp://", "tcp://", "tls://"} {
+		if strings.HasPrefix(s, p) { scheme, s = p, strings.TrimPrefix(s, p) }
+	}
+	if scheme == "tls://" { port = "853" }
+	if _, _, err := net.SplitHostPort(s); err != nil { s = net.JoinHostPort(strings.Trim(s, "[]"), port) }
+	if scheme == "udp://" { scheme = "" }
+	return scheme + s
+}
+
+// send delivers a packed query to server over the transport its prefix
+// selects. Plain servers get UDP, retried over TCP when truncated.
+func (r *Resolver) send(ctx context.Context, server string, b []byte) (*dnsmessage.Message, error) {
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		return r.doh(ctx, server, b)
+	case strings.HasPrefix(server, "tls://"):
+		return r.roundTrip(ctx, "tls", strings.TrimPrefix(server, "tls://"), b)
+	case strings.HasPrefix(server, "tcp://"):
+		return r.roundTrip(ctx, "tcp", strings.TrimPrefix(server, "tcp://"), b)
+	}
+	msg, err := r.roundTrip(ctx, "udp", server, b)
+	if err == nil && msg.Header.Truncated { msg, err = r.roundTrip(ctx, "tcp", server, b) }
+	return msg, err
+}
+
+// doh POSTs the query to a DNS over HTTPS endpoint (RFC 8484).
+func (r *Resolver) doh(ctx context.Context, url string, b []byte) (*dnsmessage.Message, error) {
+	timeout := r.Timeout
+	if timeout == 0 { timeout = 3 * time.Second }
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil { return nil, err }
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	resp, err := r.dohClient().Do(req)
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return nil, fmt.Errorf("dns: doh %s: %s", url, resp.Status) }
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, dohMediaType) { return nil, fmt.Errorf("dns: doh %s: unexpected content type %q", url, ct) }
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil { return nil, err }
+	var msg dnsmessage.Message
+	if err := msg.Unpack(body); err != nil { return nil, err }
+	return &msg, nil
+}
+
+// dohClient returns the shared DoH client, dialing through r.Dial.
+func (r *Resolver) dohClient() *http.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.httpc == nil {
+		tr := &http.Transport{DialContext: r.Dial, TLSClientConfig: r.TLSConfig, ForceAttemptHTTP2: true, MaxIdleConnsPerHost: 4, IdleConnTimeout: 90 * time.Second}
+		r.httpc = &http.Client{Transport: tr}
+	}
+	return r.httpc
+}
+
+// tlsConfig returns the DoT client config for server, naming it for
+// certificate verification.
+func (r *Resolver) tlsConfig(server string) *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if r.TLSConfig != nil { cfg = r.TLSConfig.Clone() }
+	if cfg.ServerName == "" {
+		host, _, _ := net.SplitHostPort(server)
+		cfg.ServerName = host
+	}
+	return cfg
+}
@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gustycube/spyder/internal/dns/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohServer serves f over DNS over HTTPS at /dns-query.
+func dohServer(t *testing.T, f *dnstest.Server) (*httptest.Server, string) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/dns-query" || req.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(req.Body)
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(f.Answer(b, false))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, srv.URL + "/dns-query"
+}
+
+// dotServer serves f over DNS over TLS with the certificate of srv.
+func dotServer(t *testing.T, srv *httptest.Server, f *dnstest.Server) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				for {
+					var n [2]byte
+					if _, err := io.ReadFull(c, n[:]); err != nil {
+						return
+					}
+					req := make([]byte, binary.BigEndian.Uint16(n[:]))
+					if _, err := io.ReadFull(c, req); err != nil {
+						return
+					}
+					b := f.Answer(req, false)
+					c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+				}
+			}(c)
+		}
+	}()
+	return "tls://" + ln.Addr().String()
+}
+
+func roots(srv *httptest.Server) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	return &tls.Config{RootCAs: pool}
+}
+
+func TestResolver_Transports(t *testing.T) {
+	zone := exampleZone()
+	srv, doh := dohServer(t, zone)
+	dot := dotServer(t, srv, zone)
+
+	for name, server := range map[string]string{"doh": doh, "dot": dot} {
+		r := NewResolver([]string{server})
+		r.TLSConfig = roots(srv)
+
+		rec := r.Lookup(context.Background(), "www.example.com")
+		if len(rec.A) != 1 || rec.A[0] != "192.0.2.10" {
+			t.Errorf("%s: expected A 192.0.2.10, got %v", name, rec.A)
+		}
+		if len(rec.CNAMEs) != 1 || rec.CNAMEs[0] != "edge.cdn.net" {
+			t.Errorf("%s: expected CNAME edge.cdn.net, got %v", name, rec.CNAMEs)
+		}
+		// stream transports are never truncated
+		if rec := r.Lookup(context.Background(), "example.com"); len(rec.MX) != 1 || rec.MX[0] != "mx1.example.com" {
+			t.Errorf("%s: expected MX mx1.example.com, got %v", name, rec.MX)
+		}
+	}
+}
+
+func TestResolver_TransportVerifiesCert(t *testing.T) {
+	zone := exampleZone()
+	srv, doh := dohServer(t, zone)
+	dot := dotServer(t, srv, zone)
+
+	for name, server := range map[string]string{"doh": doh, "dot": dot} {
+		r := NewResolver([]string{server})
+		if _, err := r.Query(context.Background(), "www.example.com", dnsmessage.TypeA); err == nil {
+			t.Errorf("%s: expected an untrusted certificate to fail", name)
+		}
+	}
+}
+
+func TestResolver_DoHTimeout(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { <-block }))
+	t.Cleanup(func() { close(block); srv.Close() })
+
+	r := NewResolver([]string{srv.URL + "/dns-query"})
+	r.TLSConfig = roots(srv)
+	r.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	if _, err := r.Query(context.Background(), "www.example.com", dnsmessage.TypeA); err == nil {
+		t.Error("expected a timeout from a stalled DoH server")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("expected the timeout to bound the query, took %v", d)
+	}
+}
+
+func TestNormalizeServer(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.1":                      "10.0.0.1:53",
+		"udp://10.0.0.1":                "10.0.0.1:53",
+		"tcp://10.0.0.1:5353":           "tcp://10.0.0.1:5353",
+		"tls://dns.example":             "tls://dns.example:853",
+		"tls://[2001:db8::53]":          "tls://[2001:db8::53]:853",
+		"https://dns.example/dns-query": "https://dns.example/dns-query",
+	}
+	for in, want := range cases {
+		if got := normalizeServer(in); got != want {
+			t.Errorf("normalizeServer(%q): expected %s, got %s", in, want, got)
+		}
+	}
+	if r := NewResolver([]string{" tls://dns.example ", ""}); strings.Join(r.Servers, ",") != "tls://dns.example:853" {
+		t.Errorf("expected one normalized server, got %v", r.Servers)
+	}
+}
@@ -0,0 +1,213 @@
+package egress
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// DialFunc matches net.Dialer.DialContext and http.Transport.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Config selects how probe traffic leaves the host.
+type Config struct {
+	// Proxy is an http:// (CONNECT) or socks5:// URL, optionally with
+	// user:password credentials. Empty dials directly.
+	Proxy string
+	// SourceIP binds outgoing connections (to the target or to the proxy)
+	// to a local address.
+	SourceIP string
+}
+
+// Dialer makes TCP connections according to a Config. With an HTTP proxy,
+// connections are tunnelled with CONNECT so TLS probes and HTTPS requests
+// keep their own handshake, while an http.Transport using Proxy sends
+// plain-HTTP requests to the proxy in absolute form, as proxies expect.
+// The proxy resolves the host of those requests itself, so IPs pinned with
+// WithIPs do not apply to them.
+type Dialer struct {
+	base  *net.Dialer
+	proxy *url.URL
+	socks proxy.ContextDialer
+}
+
+// New validates cfg and returns a Dialer for it.
+func New(cfg Config) (*Dialer, error) {
+	d := &Dialer{base: &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}}
+	if cfg.SourceIP != "" {
+		ip := net.ParseIP(cfg.SourceIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source ip: %s", cfg.SourceIP)
+		}
+		d.base.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if cfg.Proxy == "" {
+		return d, nil
+	}
+	u, err := url.Parse(cfg.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url: missing host")
+	}
+	switch u.Scheme {
+	case "http":
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "8080")
+		}
+		d.proxy = u
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			pw, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: pw}
+		}
+		sd, err := proxy.SOCKS5("tcp", u.Host, auth, d.base)
+		if err != nil {
+			return nil, err
+		}
+		d.socks = sd.(proxy.ContextDialer)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	return d, nil
+}
+
+// Proxy is an http.Transport Proxy function: it returns the HTTP proxy
+// for plain-HTTP requests and nil for everything else, which DialContext
+// tunnels or dials directly.
+func (d *Dialer) Proxy(req *http.Request) (*url.URL, error) {
+	if d.proxy == nil || req.URL.Scheme != "http" {
+		return nil, nil
+	}
+	return d.proxy, nil
+}
+
+type pinKey struct{}
+
+type pin struct {
+	host string
+	ips  []string
+}
+
+// WithIPs pins dials to host made with ctx to ips, tried in order, so that
+// connections reach the addresses already resolved instead of whatever the
+// system resolver (or the proxy) picks. Other hosts are unaffected.
+func WithIPs(ctx context.Context, host string, ips []string) context.Context {
+	return context.WithValue(ctx, pinKey{}, pin{host: strings.ToLower(host), ips: ips})
+}
+
+// PinnedIP reports the IP a connection made under WithIPs was dialed to,
+// or "" for connections that were not pinned.
+func PinnedIP(c net.Conn) string {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if pc, ok := c.(*pinnedConn); ok {
+		return pc.ip
+	}
+	return ""
+}
+
+type pinnedConn struct {
+	net.Conn
+	ip string
+}
+
+// DialContext connects to addr through the configured egress, honouring
+// any IPs pinned with WithIPs.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	pn, ok := ctx.Value(pinKey{}).(pin)
+	if !ok || len(pn.ips) == 0 {
+		return d.dial(ctx, network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !strings.EqualFold(host, pn.host) {
+		return d.dial(ctx, network, addr)
+	}
+	for _, ip := range pn.ips {
+		var c net.Conn
+		if c, err = d.dial(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+			return &pinnedConn{Conn: c, ip: ip}, nil
+		}
+	}
+	return nil, err
+}
+
+func (d *Dialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch {
+	case d.socks != nil:
+		return d.socks.DialContext(ctx, network, addr)
+	case d.proxy != nil && addr == d.proxy.Host:
+		// the transport's own connection for a proxied plain-HTTP request
+		return d.base.DialContext(ctx, network, addr)
+	case d.proxy != nil:
+		return d.connect(ctx, addr)
+	}
+	return d.base.DialContext(ctx, network, addr)
+}
+
+// connect opens a CONNECT tunnel to addr through the HTTP proxy.
+func (d *Dialer) connect(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := d.base.DialContext(ctx, "tcp", d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	// never wait on the proxy's answer longer than on the dial itself
+	dl := time.Now().Add(d.base.Timeout)
+	if cdl, ok := ctx.Deadline(); ok && cdl.Before(dl) {
+		dl = cdl
+	}
+	conn.SetDeadline(dl)
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := d.proxy.User; u != nil {
+		pw, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pw)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT %s: %s", addr, strings.TrimSpace(resp.Status))
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns bytes the proxy sent after its CONNECT response
+// before reading from the connection itself.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
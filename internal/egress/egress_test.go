@@ -0,0 +1,324 @@
+package egress
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// connectProxy is a minimal HTTP CONNECT proxy requiring basic auth.
+func connectProxy(t *testing.T, tunnels *int32) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil || req.Method != "CONNECT" {
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+					io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				up, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer up.Close()
+				atomic.AddInt32(tunnels, 1)
+				io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(up, c)
+				io.Copy(c, up)
+			}(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// socksProxy is a minimal unauthenticated SOCKS5 proxy (CONNECT only).
+func socksProxy(t *testing.T, tunnels *int32) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				buf := make([]byte, 262)
+				if _, err := io.ReadFull(c, buf[:2]); err != nil {
+					return
+				}
+				io.ReadFull(c, buf[:buf[1]])
+				c.Write([]byte{5, 0})
+				if _, err := io.ReadFull(c, buf[:4]); err != nil {
+					return
+				}
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(c, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(c, buf[:1])
+					n := int(buf[0])
+					io.ReadFull(c, buf[:n])
+					host = string(buf[:n])
+				default:
+					return
+				}
+				io.ReadFull(c, buf[:2])
+				port := binary.BigEndian.Uint16(buf[:2])
+				up, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+				if err != nil {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer up.Close()
+				atomic.AddInt32(tunnels, 1)
+				c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+				go io.Copy(up, c)
+				io.Copy(c, up)
+			}(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func getThrough(t *testing.T, d *Dialer, target string) string {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{DialContext: d.DialContext}, Timeout: 5 * time.Second}
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("request through egress failed: %v", err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b)
+}
+
+func TestDialer_HTTPConnect(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+
+	var tunnels int32
+	addr := connectProxy(t, &tunnels)
+
+	d, err := New(Config{Proxy: "http://user:secret@" + addr})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body := getThrough(t, d, target.URL); body != "ok" {
+		t.Errorf("expected body 'ok', got %q", body)
+	}
+	if atomic.LoadInt32(&tunnels) != 1 {
+		t.Errorf("expected 1 tunnel through the proxy, got %d", tunnels)
+	}
+
+	bad, _ := New(Config{Proxy: "http://user:wrong@" + addr})
+	if _, err := bad.DialContext(context.Background(), "tcp", target.Listener.Addr().String()); err == nil {
+		t.Error("expected CONNECT with bad credentials to fail")
+	}
+}
+
+func TestDialer_SOCKS5(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+
+	var tunnels int32
+	addr := socksProxy(t, &tunnels)
+
+	d, err := New(Config{Proxy: "socks5://" + addr})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body := getThrough(t, d, target.URL); body != "ok" {
+		t.Errorf("expected body 'ok', got %q", body)
+	}
+	if atomic.LoadInt32(&tunnels) != 1 {
+		t.Errorf("expected 1 tunnel through the proxy, got %d", tunnels)
+	}
+}
+
+func TestDialer_SourceIP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	remote := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			remote <- c.RemoteAddr().(*net.TCPAddr).IP.String()
+			c.Close()
+		}
+	}()
+
+	d, err := New(Config{SourceIP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	c.Close()
+	if ip := <-remote; ip != "127.0.0.1" {
+		t.Errorf("expected source 127.0.0.1, got %s", ip)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{SourceIP: "not-an-ip"},
+		{Proxy: "ftp://proxy:21"},
+		{Proxy: "http://"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestDialer_PinnedIPs(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer target.Close()
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+
+	d, _ := New(Config{})
+	// the first address refuses connections, the second serves
+	ctx := WithIPs(context.Background(), "unresolvable.invalid", []string{"127.0.0.2", "127.0.0.1"})
+	c, err := d.DialContext(ctx, "tcp", net.JoinHostPort("unresolvable.invalid", port))
+	if err != nil {
+		t.Fatalf("pinned dial failed: %v", err)
+	}
+	served := PinnedIP(c)
+	c.Close()
+	if served != "127.0.0.1" {
+		t.Errorf("expected connection pinned to 127.0.0.1, got %q", served)
+	}
+
+	other, err := d.DialContext(ctx, "tcp", target.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unpinned dial failed: %v", err)
+	}
+	defer other.Close()
+	if ip := PinnedIP(other); ip != "" {
+		t.Errorf("expected dial to another host to be unpinned, got %q", ip)
+	}
+}
+
+func TestDialer_HTTPProxyAbsoluteForm(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	uris := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil {
+			return
+		}
+		uris <- req.Method + " " + req.RequestURI + " " + req.Header.Get("Proxy-Authorization")
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 7\r\nConnection: close\r\n\r\nproxied")
+	}()
+
+	d, err := New(Config{Proxy: "http://user:secret@" + ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{DialContext: d.DialContext, Proxy: d.Proxy}, Timeout: 5 * time.Second}
+	resp, err := client.Get("http://plain.example.test/path?q=1")
+	if err != nil {
+		t.Fatalf("request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); string(b) != "proxied" {
+		t.Errorf("expected body 'proxied', got %q", b)
+	}
+	if got := <-uris; got != "GET http://plain.example.test/path?q=1 Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("expected an absolute-form GET with credentials, got %q", got)
+	}
+
+	https, _ := http.NewRequest("GET", "https://tls.example.test/", nil)
+	if u, _ := d.Proxy(https); u != nil {
+		t.Errorf("expected HTTPS requests to be tunnelled, got proxy %v", u)
+	}
+}
+
+func TestDialer_ConnectDeadline(t *testing.T) {
+	// accepts the connection but never answers the CONNECT
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 4)
+	t.Cleanup(func() {
+		ln.Close()
+		for {
+			select {
+			case c := <-conns:
+				c.Close()
+			default:
+				return
+			}
+		}
+	})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+
+	d, _ := New(Config{Proxy: "http://" + ln.Addr().String()})
+	d.base.Timeout = 200 * time.Millisecond
+	start := time.Now()
+	if _, err := d.DialContext(context.Background(), "tcp", "example.test:443"); err == nil {
+		t.Fatal("expected CONNECT to a silent proxy to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected CONNECT to give up after the dial timeout, took %v", elapsed)
+	}
+}
@@ -17,43 +17,188 @@ import (
 	"go.uber.org/zap"
 )
 
+// Edge is a typed relationship. Attrs qualifies it, e.g. a RESOLVES_TO
+// that only matches the zone's wildcard carries wildcard=true.
 type Edge struct {
-	Type       string    `json:"type"`
-	Source     string    `json:"source"`
-	Target     string    `json:"target"`
-	ObservedAt time.Time `json:"observed_at"`
-	ProbeID    string    `json:"probe_id"`
-	RunID      string    `json:"run_id"`
+	Type       string            `json:"type"`
+	Source     string            `json:"source"`
+	Target     string            `json:"target"`
+	Attrs      map[string]string `json:"attrs,omitempty"`
+	ObservedAt time.Time         `json:"observed_at"`
+	ProbeID    string            `json:"probe_id"`
+	RunID      string            `json:"run_id"`
 }
 
+// NodeDomain is a host name. DNSSEC and Wildcard are set on zone apexes. Status is the
+// overall DNS outcome for the host (resolves, nodata, nxdomain, servfail,
+// refused, timeout or error) and RCodes the outcome per record type; both
+// are empty for names only seen as link or record targets. Provider and
+// Service name the cloud or CDN serving the host, from its CNAME targets
+// or addresses.
 type NodeDomain struct {
-	Host      string    `json:"host"`
-	Apex      string    `json:"apex"`
-	FirstSeen time.Time `json:"first_seen"`
-	LastSeen  time.Time `json:"last_seen"`
+	Host      string            `json:"host"`
+	Apex      string            `json:"apex"`
+	DNSSEC    string            `json:"dnssec,omitempty"`
+	Wildcard  bool              `json:"wildcard,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	RCodes    map[string]string `json:"rcodes,omitempty"`
+	Provider  string            `json:"provider,omitempty"`
+	Service   string            `json:"service,omitempty"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
 }
 
+// NodeIP is an address. Country comes from local MMDB enrichment and is
+// empty when it is off or the address is unknown; Provider and Service
+// come from the published IP ranges of clouds and CDNs.
 type NodeIP struct {
 	IP        string    `json:"ip"`
+	Version   int       `json:"version,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	Service   string    `json:"service,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// NodeASN is an autonomous system. Key is how edges refer to it,
+// AS<number>.
+type NodeASN struct {
+	Key       string    `json:"key"`
+	ASN       uint32    `json:"asn"`
+	Org       string    `json:"org,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// NodeProvider is a cloud, CDN or hosting provider, keyed by Name in
+// HOSTED_ON edges.
+type NodeProvider struct {
+	Name      string    `json:"name"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// NodeRegistrar is a domain registrar, keyed by Name in REGISTERED_WITH
+// edges.
+type NodeRegistrar struct {
+	Name      string    `json:"name"`
+	IANAID    string    `json:"iana_id,omitempty"`
 	FirstSeen time.Time `json:"first_seen"`
 	LastSeen  time.Time `json:"last_seen"`
 }
 
+// NodeRegistration is an apex domain's registry record from RDAP.
+// Nameservers are those held at the registry, which may differ from what
+// the zone itself publishes. Dates that were not published are nil.
+type NodeRegistration struct {
+	Domain        string     `json:"domain"`
+	Registrar     string     `json:"registrar,omitempty"`
+	RegistrantOrg string     `json:"registrant_org,omitempty"`
+	Created       *time.Time `json:"created,omitempty"`
+	Updated       *time.Time `json:"updated,omitempty"`
+	Expires       *time.Time `json:"expires,omitempty"`
+	Nameservers   []string   `json:"nameservers,omitempty"`
+	Status        []string   `json:"status,omitempty"`
+	ObservedAt    time.Time  `json:"observed_at"`
+}
+
+// NodePrefix is an announced prefix in CIDR form.
+type NodePrefix struct {
+	Prefix    string    `json:"prefix"`
+	ASN       uint32    `json:"asn,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// NodeCert is a certificate, emitted once per SPKI per run. NotAfter is
+// the source of truth for expiry: DaysToExpiry is computed when the
+// certificate is first observed and is not refreshed for the rest of the
+// run, so consumers tracking expiry should derive it from NotAfter.
 type NodeCert struct {
-	SPKI      string    `json:"spki_sha256"`
-	SubjectCN string    `json:"subject_cn"`
-	IssuerCN  string    `json:"issuer_cn"`
-	NotBefore time.Time `json:"not_before"`
-	NotAfter  time.Time `json:"not_after"`
+	SPKI         string    `json:"spki_sha256"`
+	SubjectCN    string    `json:"subject_cn"`
+	IssuerCN     string    `json:"issuer_cn"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	DaysToExpiry int       `json:"days_to_expiry"`
+	SelfSigned   bool      `json:"self_signed"`
+}
+
+// NodeHTTP is one HTTP response observation for a host. Header values are
+// kept verbatim; an empty HSTS or CSP means the header was absent. Failed
+// fetches carry only the URL and Error.
+type NodeHTTP struct {
+	Host          string    `json:"host"`
+	URL           string    `json:"url"`
+	FinalURL      string    `json:"final_url"`
+	StatusCode    int       `json:"status_code"`
+	Proto         string    `json:"proto"`
+	IP            string    `json:"ip,omitempty"`
+	Server        string    `json:"server,omitempty"`
+	PoweredBy     string    `json:"powered_by,omitempty"`
+	HSTS          string    `json:"hsts"`
+	CSP           string    `json:"csp"`
+	ContentType   string    `json:"content_type,omitempty"`
+	ContentLength int64     `json:"content_length"`
+	ResponseMS    int64     `json:"response_ms"`
+	Error         string    `json:"error,omitempty"`
+	ObservedAt    time.Time `json:"observed_at"`
+}
+
+// NodeTLS is one TLS handshake observation for a host. Validation is the
+// certificate verification outcome for this host (valid, expired,
+// not_yet_valid, self_signed, untrusted or name_mismatch). Fingerprint is
+// a JARM-style hash over several probe handshakes and is empty unless
+// fingerprinting is enabled.
+type NodeTLS struct {
+	Host        string    `json:"host"`
+	IP          string    `json:"ip,omitempty"`
+	Version     string    `json:"version"`
+	CipherSuite string    `json:"cipher_suite"`
+	ALPN        string    `json:"alpn,omitempty"`
+	OCSPStapled bool      `json:"ocsp_stapled"`
+	Validation  string    `json:"validation,omitempty"`
+	Trusted     bool      `json:"trusted"`
+	NameMatch   bool      `json:"name_match"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	ObservedAt  time.Time `json:"observed_at"`
+}
+
+// Finding is a risk inferred from observations rather than observed
+// directly, such as a CNAME that points at an unclaimed cloud resource.
+// Evidence lists the observations that support it.
+type Finding struct {
+	Kind       string    `json:"kind"`
+	Host       string    `json:"host"`
+	Target     string    `json:"target,omitempty"`
+	Service    string    `json:"service,omitempty"`
+	Evidence   []string  `json:"evidence"`
+	ObservedAt time.Time `json:"observed_at"`
+	ProbeID    string    `json:"probe_id"`
+	RunID      string    `json:"run_id"`
 }
 
 type Batch struct {
-	ProbeID string       `json:"probe_id"`
-	RunID   string       `json:"run_id"`
-	NodesD  []NodeDomain `json:"nodes_domain"`
-	NodesIP []NodeIP     `json:"nodes_ip"`
-	NodesC  []NodeCert   `json:"nodes_cert"`
-	Edges   []Edge       `json:"edges"`
+	ProbeID   string             `json:"probe_id"`
+	RunID     string             `json:"run_id"`
+	NodesD    []NodeDomain       `json:"nodes_domain"`
+	NodesIP   []NodeIP           `json:"nodes_ip"`
+	NodesC    []NodeCert         `json:"nodes_cert"`
+	NodesHTTP []NodeHTTP         `json:"nodes_http,omitempty"`
+	NodesTLS  []NodeTLS          `json:"nodes_tls,omitempty"`
+	NodesASN  []NodeASN          `json:"nodes_asn,omitempty"`
+	NodesPfx  []NodePrefix       `json:"nodes_prefix,omitempty"`
+	NodesProv []NodeProvider     `json:"nodes_provider,omitempty"`
+	NodesRgr  []NodeRegistrar    `json:"nodes_registrar,omitempty"`
+	NodesReg  []NodeRegistration `json:"nodes_registration,omitempty"`
+	Edges     []Edge             `json:"edges"`
+	Findings  []Finding          `json:"findings,omitempty"`
+}
+
+// NodeCount is the number of nodes of every type in the batch.
+func (b *Batch) NodeCount() int {
+	return len(b.NodesD) + len(b.NodesIP) + len(b.NodesC) + len(b.NodesHTTP) + len(b.NodesTLS) + len(b.NodesASN) + len(b.NodesPfx) + len(b.NodesProv) + len(b.NodesRgr) + len(b.NodesReg)
 }
 
 type Emitter struct {
@@ -92,7 +237,7 @@ func (e *Emitter) Run(ctx context.Context, in <-chan Batch, log *zap.SugaredLogg
 		case b, ok := <-in:
 			if !ok { return }
 			e.append(b)
-			if len(e.acc.Edges) >= e.batchMax || (len(e.acc.NodesD)+len(e.acc.NodesIP)+len(e.acc.NodesC)) >= e.batchMax/2 {
+			if len(e.acc.Edges) >= e.batchMax || e.acc.NodeCount() >= e.batchMax/2 {
 				e.flush(log)
 				if !t.Stop() { select { case <-t.C: default: } }
 				t.Reset(e.flushEvery)
@@ -112,13 +257,21 @@ func (e *Emitter) append(b Batch) {
 	e.acc.NodesD = append(e.acc.NodesD, b.NodesD...)
 	e.acc.NodesIP = append(e.acc.NodesIP, b.NodesIP...)
 	e.acc.NodesC = append(e.acc.NodesC, b.NodesC...)
+	e.acc.NodesHTTP = append(e.acc.NodesHTTP, b.NodesHTTP...)
+	e.acc.NodesTLS = append(e.acc.NodesTLS, b.NodesTLS...)
+	e.acc.NodesASN = append(e.acc.NodesASN, b.NodesASN...)
+	e.acc.NodesPfx = append(e.acc.NodesPfx, b.NodesPfx...)
+	e.acc.NodesProv = append(e.acc.NodesProv, b.NodesProv...)
+	e.acc.NodesRgr = append(e.acc.NodesRgr, b.NodesRgr...)
+	e.acc.NodesReg = append(e.acc.NodesReg, b.NodesReg...)
 	e.acc.Edges = append(e.acc.Edges, b.Edges...)
+	e.acc.Findings = append(e.acc.Findings, b.Findings...)
 }
 
 func (e *Emitter) flush(log *zap.SugaredLogger) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	if len(e.acc.Edges)+len(e.acc.NodesD)+len(e.acc.NodesIP)+len(e.acc.NodesC) == 0 { return }
+	if len(e.acc.Edges)+e.acc.NodeCount()+len(e.acc.Findings) == 0 { return }
 	if e.ingest == "" {
 		_ = json.NewEncoder(os.Stdout).Encode(e.acc)
 	} else {
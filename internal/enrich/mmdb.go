@@ -0,0 +1,101 @@
+package enrich
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// IPInfo is what the local databases know about one address. Zero fields
+// are unknown.
+type IPInfo struct {
+	ASN     uint32
+	Org     string
+	Prefix  string // announced prefix in CIDR form
+	Country string // ISO 3166-1 alpha-2
+}
+
+// MMDB looks addresses up in local MaxMind or IPinfo format databases, such
+// as GeoLite2-ASN plus GeoLite2-Country or a single IPinfo country_asn
+// file. No lookup touches the network.
+type MMDB struct {
+	readers []*maxminddb.Reader
+}
+
+// OpenMMDB opens every database in paths. Each address is looked up in all
+// of them and the first database to supply a field wins.
+func OpenMMDB(paths ...string) (*MMDB, error) {
+	m := &MMDB{}
+	for _, p := range paths {
+		rd, err := maxminddb.Open(p)
+		if err != nil { m.Close(); return nil, fmt.Errorf("open mmdb %s: %w", p, err) }
+		m.readers = append(m.readers, rd)
+	}
+	return m, nil
+}
+
+// Close releases every database.
+func (m *MMDB) Close() error {
+	var errs []error
+	for _, rd := range m.readers { errs = append(errs, rd.Close()) }
+	return errors.Join(errs...)
+}
+
+// Lookup returns what the databases know about ip.
+func (m *MMDB) Lookup(ip string) IPInfo {
+	var info IPInfo
+	addr := net.ParseIP(ip)
+	if addr == nil { return info }
+	for _, rd := range m.readers {
+		var rec map[string]any
+		network, ok, err := rd.LookupNetwork(addr, &rec)
+		if err != nil || !ok { continue }
+		asn, org, route := asnFields(rec)
+		if info.ASN == 0 && asn != 0 {
+			info.ASN, info.Org = asn, org
+			// without an explicit route the ASN database's network is the
+			// announced prefix
+			if route == "" && network != nil { route = network.String() }
+			info.Prefix = route
+		}
+		if info.Country == "" { info.Country = country(rec) }
+	}
+	return info
+}
+
+// asnFields reads GeoLite2-ASN (autonomous_system_*) and IPinfo (asn as
+// "AS123", as_name or name, route) records.
+func asnFields(rec map[string]any) (asn uint32, org, route string) {
+	switch v := rec["autonomous_system_number"].(type) {
+	case uint64:
+		asn = uint32(v)
+	case uint32:
+		asn = v
+	}
+	if s, ok := rec["asn"].(string); ok && asn == 0 {
+		if n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "AS"), 10, 32); err == nil { asn = uint32(n) }
+	}
+	for _, k := range []string{"autonomous_system_organization", "as_name", "name"} {
+		if s, ok := rec[k].(string); ok && s != "" { org = s; break }
+	}
+	route, _ = rec["route"].(string)
+	return asn, org, route
+}
+
+// country reads MaxMind's country.iso_code or IPinfo's country string.
+func country(rec map[string]any) string {
+	switch v := rec["country"].(type) {
+	case string:
+		return strings.ToUpper(v)
+	case map[string]any:
+		if s, ok := v["iso_code"].(string); ok { return s }
+	}
+	return ""
+}
+
+// ASNKey is the graph identifier of an autonomous system, e.g. AS15169.
+func ASNKey(asn uint32) string { return "AS" + strconv.FormatUint(uint64(asn), 10) }
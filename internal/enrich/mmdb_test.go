@@ -0,0 +1,203 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// writeMMDB writes a minimal IPv4 MaxMind DB mapping each CIDR in nets to
+// its record. Prefixes must not overlap.
+func writeMMDB(t *testing.T, dbType string, nets map[string]map[string]any) string {
+	t.Helper()
+	type tnode struct{ kids [2]any } // *tnode, int data offset or nil
+	root := &tnode{}
+	var data bytes.Buffer
+	for cidr, rec := range nets {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off := data.Len()
+		encode(&data, rec)
+		ones, _ := n.Mask.Size()
+		ip, cur := n.IP.To4(), root
+		for i := 0; i < ones; i++ {
+			b := ip[i/8] >> (7 - i%8) & 1
+			if i == ones-1 {
+				cur.kids[b] = off
+				break
+			}
+			next, ok := cur.kids[b].(*tnode)
+			if !ok {
+				next = &tnode{}
+				cur.kids[b] = next
+			}
+			cur = next
+		}
+	}
+
+	// number nodes breadth first so the root is 0
+	nodes, ids := []*tnode{root}, map[*tnode]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, k := range nodes[i].kids {
+			if n, ok := k.(*tnode); ok {
+				ids[n] = len(nodes)
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	count := len(nodes)
+	var out bytes.Buffer
+	for _, n := range nodes {
+		for _, k := range n.kids {
+			v := count // no data
+			switch k := k.(type) {
+			case *tnode:
+				v = ids[k]
+			case int:
+				v = count + 16 + k
+			}
+			out.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	encode(&out, map[string]any{
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(4),
+		"database_type":               dbType,
+		"languages":                   []any{"en"},
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"description":                 map[string]any{"en": "test"},
+	})
+	path := filepath.Join(t.TempDir(), dbType+".mmdb")
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// encode writes v in the MaxMind DB data section format.
+func encode(buf *bytes.Buffer, v any) {
+	unsigned := func(typ int, n uint64) {
+		b := binary.BigEndian.AppendUint64(nil, n)
+		b = bytes.TrimLeft(b, "\x00")
+		control(buf, typ, len(b))
+		buf.Write(b)
+	}
+	switch v := v.(type) {
+	case string:
+		control(buf, 2, len(v))
+		buf.WriteString(v)
+	case uint16:
+		unsigned(5, uint64(v))
+	case uint32:
+		unsigned(6, uint64(v))
+	case uint64:
+		unsigned(9, v)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		control(buf, 7, len(keys))
+		for _, k := range keys {
+			encode(buf, k)
+			encode(buf, v[k])
+		}
+	case []any:
+		control(buf, 11, len(v))
+		for _, e := range v {
+			encode(buf, e)
+		}
+	}
+}
+
+func control(buf *bytes.Buffer, typ, size int) {
+	ext := 0
+	if typ > 7 {
+		ext, typ = typ-7, 0
+	}
+	switch {
+	case size < 29:
+		buf.WriteByte(byte(typ<<5 | size))
+		if ext > 0 {
+			buf.WriteByte(byte(ext))
+		}
+	default:
+		buf.WriteByte(byte(typ<<5 | 29))
+		if ext > 0 {
+			buf.WriteByte(byte(ext))
+		}
+		buf.WriteByte(byte(size - 29))
+	}
+}
+
+func TestMMDB_MaxMind(t *testing.T) {
+	asn := writeMMDB(t, "GeoLite2-ASN", map[string]map[string]any{
+		"192.0.2.0/24":    {"autonomous_system_number": uint32(64500), "autonomous_system_organization": "Example Hosting"},
+		"198.51.100.0/23": {"autonomous_system_number": uint32(64501), "autonomous_system_organization": "Other Net"},
+	})
+	geo := writeMMDB(t, "GeoLite2-Country", map[string]map[string]any{
+		"192.0.2.0/25": {"country": map[string]any{"iso_code": "NL", "names": map[string]any{"en": "Netherlands"}}},
+	})
+	m, err := OpenMMDB(asn, geo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	got := m.Lookup("192.0.2.10")
+	want := IPInfo{ASN: 64500, Org: "Example Hosting", Prefix: "192.0.2.0/24", Country: "NL"}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if got := m.Lookup("198.51.101.7"); got.ASN != 64501 || got.Prefix != "198.51.100.0/23" || got.Country != "" {
+		t.Errorf("expected AS64501 in 198.51.100.0/23 without a country, got %+v", got)
+	}
+	if got := m.Lookup("203.0.113.1"); got != (IPInfo{}) {
+		t.Errorf("expected nothing for an unlisted address, got %+v", got)
+	}
+	if got := m.Lookup("not-an-ip"); got != (IPInfo{}) {
+		t.Errorf("expected nothing for a malformed address, got %+v", got)
+	}
+}
+
+func TestMMDB_IPinfo(t *testing.T) {
+	db := writeMMDB(t, "ipinfo country_asn.mmdb", map[string]map[string]any{
+		"203.0.113.0/24": {"asn": "AS64510", "as_name": "Edge CDN", "country": "de", "route": "203.0.112.0/22"},
+	})
+	m, err := OpenMMDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	got := m.Lookup("203.0.113.9")
+	want := IPInfo{ASN: 64510, Org: "Edge CDN", Prefix: "203.0.112.0/22", Country: "DE"}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestOpenMMDB_Missing(t *testing.T) {
+	if _, err := OpenMMDB(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+}
+
+func TestASNKey(t *testing.T) {
+	if got := ASNKey(15169); got != "AS15169" {
+		t.Errorf("expected AS15169, got %s", got)
+	}
+}
@@ -0,0 +1,240 @@
+package enrich
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Attribution names the provider, and where known the service, behind an
+// address or host name.
+type Attribution struct {
+	Provider string
+	Service  string
+}
+
+// Suffix attributes host names ending in Pattern. A label of the pattern
+// may hold shell-style wildcards, e.g. "awsdns-*.com".
+type Suffix struct {
+	Provider string `yaml:"provider" json:"provider"`
+	Service  string `yaml:"service" json:"service"`
+	Pattern  string `yaml:"pattern" json:"pattern"`
+}
+
+// DefaultSuffixes covers the CNAME and NS targets of the common clouds,
+// CDNs and hosting platforms.
+var DefaultSuffixes = []Suffix{
+	{"cloudflare", "cdn", "cloudflare.net"},
+	{"cloudflare", "dns", "ns.cloudflare.com"},
+	{"cloudflare", "pages", "pages.dev"},
+	{"cloudflare", "workers", "workers.dev"},
+	{"aws", "", "amazonaws.com"},
+	{"aws", "cloudfront", "cloudfront.net"},
+	{"aws", "elb", "elb.amazonaws.com"},
+	{"aws", "s3", "s3.amazonaws.com"},
+	{"aws", "s3", "s3-website*.amazonaws.com"},
+	{"aws", "elasticbeanstalk", "elasticbeanstalk.com"},
+	{"aws", "globalaccelerator", "awsglobalaccelerator.com"},
+	{"aws", "route53", "awsdns-*.com"},
+	{"aws", "route53", "awsdns-*.net"},
+	{"aws", "route53", "awsdns-*.org"},
+	{"aws", "route53", "awsdns-*.co.uk"},
+	{"azure", "app-service", "azurewebsites.net"},
+	{"azure", "cloud-service", "cloudapp.net"},
+	{"azure", "vm", "cloudapp.azure.com"},
+	{"azure", "cdn", "azureedge.net"},
+	{"azure", "front-door", "azurefd.net"},
+	{"azure", "traffic-manager", "trafficmanager.net"},
+	{"azure", "storage", "blob.core.windows.net"},
+	{"azure", "static-web-apps", "azurestaticapps.net"},
+	{"azure", "dns", "azure-dns.com"},
+	{"azure", "dns", "azure-dns.net"},
+	{"azure", "dns", "azure-dns.org"},
+	{"azure", "dns", "azure-dns.info"},
+	{"gcp", "", "googleusercontent.com"},
+	{"gcp", "app-engine", "appspot.com"},
+	{"gcp", "cloud-run", "run.app"},
+	{"gcp", "storage", "storage.googleapis.com"},
+	{"gcp", "hosted", "ghs.googlehosted.com"},
+	{"gcp", "cloud-dns", "ns-cloud-*.googledomains.com"},
+	{"fastly", "cdn", "fastly.net"},
+	{"fastly", "cdn", "fastlylb.net"},
+	{"akamai", "cdn", "akamaiedge.net"},
+	{"akamai", "cdn", "akamai.net"},
+	{"akamai", "cdn", "edgekey.net"},
+	{"akamai", "cdn", "edgesuite.net"},
+	{"akamai", "cdn", "akamaihd.net"},
+	{"akamai", "dns", "akam.net"},
+	{"vercel", "", "vercel.app"},
+	{"vercel", "", "vercel-dns.com"},
+	{"netlify", "", "netlify.app"},
+	{"netlify", "", "netlify.com"},
+	{"github", "pages", "github.io"},
+	{"heroku", "", "herokuapp.com"},
+	{"heroku", "", "herokudns.com"},
+	{"digitalocean", "spaces", "digitaloceanspaces.com"},
+	{"digitalocean", "dns", "digitalocean.com"},
+}
+
+// LoadSuffixes reads a YAML or JSON list of suffix patterns.
+func LoadSuffixes(file string) ([]Suffix, error) {
+	b, err := os.ReadFile(file)
+	if err != nil { return nil, err }
+	var sfx []Suffix
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		err = json.Unmarshal(b, &sfx)
+	default:
+		err = yaml.Unmarshal(b, &sfx)
+	}
+	if err != nil { return nil, fmt.Errorf("parse provider suffixes %s: %w", file, err) }
+	return sfx, nil
+}
+
+// Classifier attributes addresses to providers by their published IP
+// ranges and host names by suffix. Load every range file before sharing
+// it between goroutines.
+type Classifier struct {
+	suffixes []Suffix
+	ranges   map[netip.Prefix]Attribution
+	lengths  []int // distinct prefix lengths, longest first
+}
+
+// NewClassifier returns a Classifier matching host names against suffixes;
+// nil selects DefaultSuffixes.
+func NewClassifier(suffixes []Suffix) *Classifier {
+	if suffixes == nil { suffixes = DefaultSuffixes }
+	return &Classifier{suffixes: suffixes, ranges: make(map[netip.Prefix]Attribution)}
+}
+
+// LoadRanges adds the ranges in file to provider. The file may be AWS
+// ip-ranges.json, Google cloud.json, an Azure service tags file or plain
+// text with one CIDR or address per line.
+func (c *Classifier) LoadRanges(provider, file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil { return err }
+	entries, err := parseRanges(b)
+	if err != nil { return fmt.Errorf("parse ranges %s: %w", file, err) }
+	for _, e := range entries {
+		pfx, err := netip.ParsePrefix(e.prefix)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(e.prefix)
+			if aerr != nil { return fmt.Errorf("parse ranges %s: %w", file, err) }
+			pfx = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		pfx = pfx.Masked()
+		// generic entries (AWS lists every prefix as AMAZON too) never
+		// replace a specific service
+		if old, ok := c.ranges[pfx]; ok && (old.Service != "" || e.service == "") { continue }
+		c.ranges[pfx] = Attribution{Provider: provider, Service: e.service}
+		c.addLength(pfx.Bits())
+	}
+	return nil
+}
+
+func (c *Classifier) addLength(bits int) {
+	for _, l := range c.lengths {
+		if l == bits { return }
+	}
+	c.lengths = append(c.lengths, bits)
+	sort.Sort(sort.Reverse(sort.IntSlice(c.lengths)))
+}
+
+// IP returns the provider of the most specific range holding ip.
+func (c *Classifier) IP(ip string) (Attribution, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil { return Attribution{}, false }
+	addr = addr.Unmap()
+	for _, bits := range c.lengths {
+		if bits > addr.BitLen() { continue }
+		pfx, _ := addr.Prefix(bits)
+		if a, ok := c.ranges[pfx]; ok { return a, true }
+	}
+	return Attribution{}, false
+}
+
+// Host returns the provider of the longest suffix pattern host ends in.
+func (c *Classifier) Host(host string) (Attribution, bool) {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+	best, bestLen := Attribution{}, 0
+	for _, s := range c.suffixes {
+		pat := strings.Split(strings.ToLower(s.Pattern), ".")
+		if len(pat) <= bestLen || len(pat) > len(labels) || !matchLabels(pat, labels[len(labels)-len(pat):]) { continue }
+		best, bestLen = Attribution{Provider: s.Provider, Service: s.Service}, len(pat)
+	}
+	return best, bestLen > 0
+}
+
+func matchLabels(pat, labels []string) bool {
+	for i := range pat {
+		if ok, _ := path.Match(pat[i], labels[i]); !ok { return false }
+	}
+	return true
+}
+
+type rangeEntry struct{ prefix, service string }
+
+// parseRanges reads the published range formats LoadRanges accepts.
+func parseRanges(b []byte) ([]rangeEntry, error) {
+	var out []rangeEntry
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		var doc struct {
+			// AWS and Google
+			Prefixes []struct {
+				IPPrefix   string `json:"ip_prefix"`
+				IPv4Prefix string `json:"ipv4Prefix"`
+				IPv6Prefix string `json:"ipv6Prefix"`
+				Service    string `json:"service"`
+			} `json:"prefixes"`
+			IPv6Prefixes []struct {
+				IPv6Prefix string `json:"ipv6_prefix"`
+				Service    string `json:"service"`
+			} `json:"ipv6_prefixes"`
+			// Azure service tags
+			Values []struct {
+				Properties struct {
+					SystemService   string   `json:"systemService"`
+					AddressPrefixes []string `json:"addressPrefixes"`
+				} `json:"properties"`
+			} `json:"values"`
+		}
+		if err := json.Unmarshal(b, &doc); err != nil { return nil, err }
+		for _, p := range doc.Prefixes {
+			for _, pfx := range []string{p.IPPrefix, p.IPv4Prefix, p.IPv6Prefix} {
+				if pfx != "" { out = append(out, rangeEntry{pfx, service(p.Service)}) }
+			}
+		}
+		for _, p := range doc.IPv6Prefixes { out = append(out, rangeEntry{p.IPv6Prefix, service(p.Service)}) }
+		for _, v := range doc.Values {
+			for _, pfx := range v.Properties.AddressPrefixes { out = append(out, rangeEntry{pfx, service(v.Properties.SystemService)}) }
+		}
+		return out, nil
+	}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") { continue }
+		out = append(out, rangeEntry{prefix: strings.Fields(line)[0]})
+	}
+	return out, sc.Err()
+}
+
+// service normalises a published service name; AWS's catch-all AMAZON
+// and Google's generic entries carry no service.
+func service(s string) string {
+	s = strings.ToLower(s)
+	switch s {
+	case "amazon", "google cloud":
+		return ""
+	}
+	return s
+}
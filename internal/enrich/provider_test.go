@@ -0,0 +1,118 @@
+package enrich
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClassifier_IP(t *testing.T) {
+	c := NewClassifier(nil)
+	aws := writeFile(t, "ip-ranges.json", `{"prefixes":[
+		{"ip_prefix":"3.0.0.0/9","region":"ap-southeast-1","service":"AMAZON"},
+		{"ip_prefix":"3.5.0.0/16","region":"us-east-1","service":"AMAZON"},
+		{"ip_prefix":"3.5.0.0/16","region":"us-east-1","service":"S3"},
+		{"ip_prefix":"13.32.0.0/15","region":"GLOBAL","service":"AMAZON"},
+		{"ip_prefix":"13.32.0.0/15","region":"GLOBAL","service":"CLOUDFRONT"}],
+		"ipv6_prefixes":[{"ipv6_prefix":"2600:9000::/28","region":"GLOBAL","service":"CLOUDFRONT"}]}`)
+	gcp := writeFile(t, "cloud.json", `{"prefixes":[{"ipv4Prefix":"34.64.0.0/10","service":"Google Cloud","scope":"asia-east1"}]}`)
+	azure := writeFile(t, "ServiceTags.json", `{"values":[
+		{"name":"AzureCloud","properties":{"systemService":"","addressPrefixes":["20.0.0.0/11"]}},
+		{"name":"AzureFrontDoor.Frontend","properties":{"systemService":"AzureFrontDoor","addressPrefixes":["20.21.0.0/16"]}}]}`)
+	cf := writeFile(t, "ips-v4", "# Cloudflare\n104.16.0.0/13\n\n198.51.100.7\n")
+	for provider, file := range map[string]string{"aws": aws, "gcp": gcp, "azure": azure, "cloudflare": cf} {
+		if err := c.LoadRanges(provider, file); err != nil {
+			t.Fatalf("load %s: %v", provider, err)
+		}
+	}
+
+	cases := map[string]Attribution{
+		"3.5.1.1":        {"aws", "s3"},
+		"3.100.1.1":      {"aws", ""},
+		"13.33.0.1":      {"aws", "cloudfront"},
+		"2600:9000::1":   {"aws", "cloudfront"},
+		"34.80.1.1":      {"gcp", ""},
+		"20.21.5.5":      {"azure", "azurefrontdoor"},
+		"20.1.1.1":       {"azure", ""},
+		"104.18.2.2":     {"cloudflare", ""},
+		"198.51.100.7":   {"cloudflare", ""},
+		"::ffff:3.5.1.1": {"aws", "s3"},
+	}
+	for ip, want := range cases {
+		if got, ok := c.IP(ip); !ok || got != want {
+			t.Errorf("%s: expected %+v, got %+v (%v)", ip, want, got, ok)
+		}
+	}
+	for _, ip := range []string{"192.0.2.1", "198.51.100.8", "bogus"} {
+		if got, ok := c.IP(ip); ok {
+			t.Errorf("%s: expected no provider, got %+v", ip, got)
+		}
+	}
+}
+
+func TestClassifier_LoadRangesErrors(t *testing.T) {
+	c := NewClassifier(nil)
+	if err := c.LoadRanges("x", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if err := c.LoadRanges("x", writeFile(t, "bad.txt", "not-a-prefix\n")); err == nil {
+		t.Error("expected an error for a malformed prefix")
+	}
+	if err := c.LoadRanges("x", writeFile(t, "bad.json", `{"prefixes":`)); err == nil {
+		t.Error("expected an error for malformed JSON")
+	}
+}
+
+func TestClassifier_Host(t *testing.T) {
+	c := NewClassifier(nil)
+	cases := map[string]Attribution{
+		"d111111abcdef8.cloudfront.net":             {"aws", "cloudfront"},
+		"my-lb-123.us-east-1.elb.amazonaws.com":     {"aws", "elb"},
+		"ec2-3-5-1-1.compute-1.amazonaws.com":       {"aws", ""},
+		"bucket.s3-website-us-east-1.amazonaws.com": {"aws", "s3"},
+		"ns-1234.awsdns-12.org.":                    {"aws", "route53"},
+		"ns-cloud-a1.googledomains.com":             {"gcp", "cloud-dns"},
+		"kate.ns.cloudflare.com":                    {"cloudflare", "dns"},
+		"www.example.com.cdn.cloudflare.net":        {"cloudflare", "cdn"},
+		"myapp.azurewebsites.net":                   {"azure", "app-service"},
+		"EXAMPLE.GITHUB.IO":                         {"github", "pages"},
+	}
+	for host, want := range cases {
+		if got, ok := c.Host(host); !ok || got != want {
+			t.Errorf("%s: expected %+v, got %+v (%v)", host, want, got, ok)
+		}
+	}
+	for _, host := range []string{"example.com", "notcloudfront.net", "awsdns-12.example.com"} {
+		if got, ok := c.Host(host); ok {
+			t.Errorf("%s: expected no provider, got %+v", host, got)
+		}
+	}
+
+	custom := NewClassifier([]Suffix{{Provider: "internal", Service: "lb", Pattern: "lb-*.corp.example"}})
+	if got, ok := custom.Host("web.lb-3.corp.example"); !ok || got.Provider != "internal" {
+		t.Errorf("expected custom suffix to match, got %+v", got)
+	}
+}
+
+func TestLoadSuffixes(t *testing.T) {
+	y := writeFile(t, "providers.yaml", "- provider: acme\n  service: cdn\n  pattern: acmecdn.net\n")
+	j := writeFile(t, "providers.json", `[{"provider":"acme","service":"cdn","pattern":"acmecdn.net"}]`)
+	for _, f := range []string{y, j} {
+		sfx, err := LoadSuffixes(f)
+		if err != nil || len(sfx) != 1 || sfx[0] != (Suffix{"acme", "cdn", "acmecdn.net"}) {
+			t.Errorf("%s: expected one acme suffix, got %+v, %v", filepath.Base(f), sfx, err)
+		}
+	}
+	if _, err := LoadSuffixes(writeFile(t, "bad.json", "{")); err == nil {
+		t.Error("expected an error for malformed JSON")
+	}
+}
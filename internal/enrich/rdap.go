@@ -0,0 +1,280 @@
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gustycube/spyder/internal/rate"
+)
+
+// ErrNoRDAPServer is returned for domains whose TLD has no RDAP service in
+// the bootstrap file.
+var ErrNoRDAPServer = errors.New("rdap: no server for tld")
+
+// ErrRDAPNotFound is returned when the registry has no such domain.
+var ErrRDAPNotFound = errors.New("rdap: domain not found")
+
+// ErrRDAPRateLimited is returned when the registry still answers 429 Too
+// Many Requests after the client has waited out its Retry-After once.
+var ErrRDAPRateLimited = errors.New("rdap: rate limited")
+
+// Retry-After bounds: registries that send none get defaultRetryAfter, and
+// none is honoured beyond maxRetryAfter.
+const (
+	defaultRetryAfter = time.Minute
+	maxRetryAfter     = 10 * time.Minute
+)
+
+// Bootstrap maps TLDs to RDAP base URLs, as in IANA's dns.json.
+type Bootstrap map[string]string
+
+// LoadBootstrap reads an IANA RDAP bootstrap file (RFC 9224). HTTPS base
+// URLs are preferred when a service lists several.
+func LoadBootstrap(path string) (Bootstrap, error) {
+	b, err := os.ReadFile(path)
+	if err != nil { return nil, err }
+	var doc struct {
+		Services [][][]string `json:"services"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil { return nil, fmt.Errorf("parse rdap bootstrap %s: %w", path, err) }
+	bs := make(Bootstrap)
+	for _, svc := range doc.Services {
+		if len(svc) != 2 || len(svc[1]) == 0 { continue }
+		base := svc[1][0]
+		for _, u := range svc[1] {
+			if strings.HasPrefix(u, "https://") { base = u; break }
+		}
+		if !strings.HasSuffix(base, "/") { base += "/" }
+		for _, tld := range svc[0] { bs[strings.ToLower(tld)] = base }
+	}
+	return bs, nil
+}
+
+// Server returns the base URL responsible for domain, matching the longest
+// registered suffix.
+func (b Bootstrap) Server(domain string) (string, bool) {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	for i := range labels {
+		if base, ok := b[strings.Join(labels[i:], ".")]; ok { return base, true }
+	}
+	return "", false
+}
+
+// Registration is what a registry publishes about a domain. Zero times
+// were not published.
+type Registration struct {
+	Domain        string
+	Registrar     string
+	RegistrarID   string // IANA registrar ID
+	RegistrantOrg string
+	Created       time.Time
+	Updated       time.Time
+	Expires       time.Time
+	Nameservers   []string
+	Status        []string
+}
+
+// RDAP looks domains up at the registries named by a bootstrap file,
+// rate limited per registry host. A registry answering 429 is left alone
+// until its Retry-After has passed.
+type RDAP struct {
+	bootstrap Bootstrap
+	hc        *http.Client
+	ua        string
+	lim       *rate.PerHost
+
+	mu      sync.Mutex
+	backoff map[string]time.Time // registry host -> earliest next request
+}
+
+// NewRDAP returns an RDAP client making at most qps requests per second to
+// each registry; qps <= 0 means 1.
+func NewRDAP(bootstrap Bootstrap, hc *http.Client, ua string, qps float64) *RDAP {
+	if qps <= 0 { qps = 1 }
+	return &RDAP{bootstrap: bootstrap, hc: hc, ua: ua, lim: rate.New(qps, 1), backoff: make(map[string]time.Time)}
+}
+
+// Lookup fetches the registration of domain. A 429 answer is retried once
+// after its Retry-After; waits end early with ctx.
+func (c *RDAP) Lookup(ctx context.Context, domain string) (Registration, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	base, ok := c.bootstrap.Server(domain)
+	if !ok { return Registration{}, fmt.Errorf("%w: %s", ErrNoRDAPServer, domain) }
+	u, err := url.Parse(base + "domain/" + url.PathEscape(domain))
+	if err != nil { return Registration{}, err }
+	for attempt := 0; ; attempt++ {
+		resp, err := c.get(ctx, u)
+		if err != nil { return Registration{}, err }
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			c.backOff(u.Host, retryAfter(resp.Header.Get("Retry-After"), time.Now()))
+			if attempt > 0 { return Registration{}, fmt.Errorf("%w: %s", ErrRDAPRateLimited, u.Host) }
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound { return Registration{}, fmt.Errorf("%w: %s", ErrRDAPNotFound, domain) }
+		if resp.StatusCode != http.StatusOK { return Registration{}, fmt.Errorf("rdap %s: %s", u, resp.Status) }
+		var doc rdapDomain
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil { return Registration{}, fmt.Errorf("rdap %s: %w", u, err) }
+		return doc.registration(domain), nil
+	}
+}
+
+// get waits out any backoff and the rate limit for u's registry, then
+// requests u. The caller closes the body.
+func (c *RDAP) get(ctx context.Context, u *url.URL) (*http.Response, error) {
+	c.mu.Lock()
+	until := c.backoff[u.Host]
+	c.mu.Unlock()
+	if d := time.Until(until); d > 0 {
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+	if err := c.lim.WaitContext(ctx, u.Host); err != nil { return nil, err }
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil { cancel(); return nil, err }
+	req.Header.Set("Accept", "application/rdap+json, application/json")
+	req.Header.Set("User-Agent", c.ua)
+	resp, err := c.hc.Do(req)
+	if err != nil { cancel(); return nil, err }
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// backOff holds requests to host until the given time, never shortening
+// a backoff already in place.
+func (c *RDAP) backOff(host string, until time.Time) {
+	c.mu.Lock()
+	if until.After(c.backoff[host]) { c.backoff[host] = until }
+	c.mu.Unlock()
+}
+
+// retryAfter turns a Retry-After value, either delay seconds or an HTTP
+// date, into the time the next request may be made.
+func retryAfter(v string, now time.Time) time.Time {
+	d := defaultRetryAfter
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && secs >= 0 {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(now)
+	}
+	if d < 0 { d = 0 }
+	if d > maxRetryAfter { d = maxRetryAfter }
+	return now.Add(d)
+}
+
+// cancelOnClose releases a request's timeout when its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+type rdapDomain struct {
+	LDHName string `json:"ldhName"`
+	Events  []struct {
+		Action string `json:"eventAction"`
+		Date   string `json:"eventDate"`
+	} `json:"events"`
+	Status      []string `json:"status"`
+	Nameservers []struct {
+		LDHName string `json:"ldhName"`
+	} `json:"nameservers"`
+	Entities []rdapEntity `json:"entities"`
+}
+
+type rdapEntity struct {
+	Roles     []string `json:"roles"`
+	PublicIDs []struct {
+		Type       string `json:"type"`
+		Identifier string `json:"identifier"`
+	} `json:"publicIds"`
+	VCard []json.RawMessage `json:"vcardArray"`
+}
+
+func (d rdapDomain) registration(domain string) Registration {
+	reg := Registration{Domain: domain, Status: d.Status}
+	if d.LDHName != "" { reg.Domain = strings.ToLower(d.LDHName) }
+	for _, e := range d.Events {
+		switch e.Action {
+		case "registration":
+			reg.Created = rdapTime(e.Date)
+		case "expiration":
+			reg.Expires = rdapTime(e.Date)
+		case "last changed":
+			reg.Updated = rdapTime(e.Date)
+		}
+	}
+	for _, ns := range d.Nameservers {
+		if ns.LDHName != "" { reg.Nameservers = append(reg.Nameservers, strings.ToLower(strings.TrimSuffix(ns.LDHName, "."))) }
+	}
+	for _, e := range d.Entities {
+		switch {
+		case e.hasRole("registrar") && reg.Registrar == "":
+			reg.Registrar = e.vcard("fn")
+			for _, id := range e.PublicIDs {
+				if id.Type == "IANA Registrar ID" { reg.RegistrarID = id.Identifier }
+			}
+		case e.hasRole("registrant") && reg.RegistrantOrg == "":
+			if reg.RegistrantOrg = e.vcard("org"); reg.RegistrantOrg == "" { reg.RegistrantOrg = e.vcard("fn") }
+		}
+	}
+	return reg
+}
+
+// rdapTime parses an event date; registries do not all include a zone,
+// and those without one are taken as UTC.
+func rdapTime(s string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil { return t.UTC() }
+	}
+	return time.Time{}
+}
+
+func (e rdapEntity) hasRole(role string) bool {
+	for _, r := range e.Roles {
+		if r == role { return true }
+	}
+	return false
+}
+
+// vcard returns the first text value of property name in the entity's
+// jCard (RFC 7095): ["vcard", [[name, params, type, value], ...]].
+func (e rdapEntity) vcard(name string) string {
+	if len(e.VCard) != 2 { return "" }
+	var props [][]json.RawMessage
+	if json.Unmarshal(e.VCard[1], &props) != nil { return "" }
+	for _, p := range props {
+		var n, v string
+		if len(p) < 4 || json.Unmarshal(p[0], &n) != nil || n != name { continue }
+		// org values may be structured; take the first component
+		if json.Unmarshal(p[3], &v) != nil {
+			var parts []string
+			if json.Unmarshal(p[3], &parts) != nil || len(parts) == 0 { continue }
+			v = parts[0]
+		}
+		if v = strings.TrimSpace(v); v != "" { return v }
+	}
+	return ""
+}
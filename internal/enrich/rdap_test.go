@@ -0,0 +1,226 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const exampleRDAP = `{
+  "objectClassName": "domain",
+  "ldhName": "EXAMPLE.TEST",
+  "status": ["client transfer prohibited"],
+  "events": [
+    {"eventAction": "registration", "eventDate": "1995-08-14T04:00:00Z"},
+    {"eventAction": "expiration", "eventDate": "2030-08-13T04:00:00Z"},
+    {"eventAction": "last changed", "eventDate": "2024-08-14T07:01:34"}
+  ],
+  "nameservers": [
+    {"objectClassName": "nameserver", "ldhName": "A.NS.EXAMPLE.NET"},
+    {"objectClassName": "nameserver", "ldhName": "b.ns.example.net."}
+  ],
+  "entities": [
+    {"objectClassName": "entity", "roles": ["registrar"],
+     "publicIds": [{"type": "IANA Registrar ID", "identifier": "9999"}],
+     "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Registrar, Inc."]]]},
+    {"objectClassName": "entity", "roles": ["registrant"],
+     "vcardArray": ["vcard", [["fn", {}, "text", "Jane Doe"], ["org", {}, "text", ["Example Org", "Ops"]]]]}
+  ]
+}`
+
+func rdapServer(t *testing.T, hits *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		if r.Header.Get("User-Agent") != "TestBot/1.0" {
+			http.Error(w, "no ua", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/rdap/domain/example.test", "/rdap/domain/other.test":
+			w.Header().Set("Content-Type", "application/rdap+json")
+			fmt.Fprint(w, exampleRDAP)
+		case "/rdap/domain/broken.test":
+			fmt.Fprint(w, "{")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLoadBootstrap(t *testing.T) {
+	path := writeFile(t, "dns.json", `{"version": "1.0", "services": [
+		[["com", "net"], ["http://rdap.verisign.test/com/v1/", "https://rdap.verisign.test/com/v1/"]],
+		[["uk"], ["https://rdap.nominet.test/uk"]],
+		[["co.uk"], ["https://rdap.co-uk.test/"]]]}`)
+	b, err := LoadBootstrap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"example.com":    "https://rdap.verisign.test/com/v1/",
+		"EXAMPLE.NET.":   "https://rdap.verisign.test/com/v1/",
+		"example.org.uk": "https://rdap.nominet.test/uk/",
+		"example.co.uk":  "https://rdap.co-uk.test/",
+	}
+	for domain, want := range cases {
+		if got, ok := b.Server(domain); !ok || got != want {
+			t.Errorf("%s: expected %s, got %q", domain, want, got)
+		}
+	}
+	if _, ok := b.Server("example.invalid"); ok {
+		t.Error("expected no server for an unlisted tld")
+	}
+}
+
+func TestRDAP_Lookup(t *testing.T) {
+	var hits int32
+	srv := rdapServer(t, &hits)
+	c := NewRDAP(Bootstrap{"test": srv.URL + "/rdap/"}, srv.Client(), "TestBot/1.0", 100)
+
+	reg, err := c.Lookup(context.Background(), "Example.Test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.Domain != "example.test" || reg.Registrar != "Example Registrar, Inc." || reg.RegistrarID != "9999" {
+		t.Errorf("expected the registrar and its IANA ID, got %+v", reg)
+	}
+	if reg.RegistrantOrg != "Example Org" {
+		t.Errorf("expected the registrant org over its fn, got %q", reg.RegistrantOrg)
+	}
+	if !reg.Created.Equal(time.Date(1995, 8, 14, 4, 0, 0, 0, time.UTC)) || reg.Expires.Year() != 2030 || reg.Updated.Year() != 2024 {
+		t.Errorf("expected registration, expiry and update dates, got %v %v %v", reg.Created, reg.Expires, reg.Updated)
+	}
+	if strings.Join(reg.Nameservers, ",") != "a.ns.example.net,b.ns.example.net" {
+		t.Errorf("expected normalised registry nameservers, got %v", reg.Nameservers)
+	}
+	if len(reg.Status) != 1 {
+		t.Errorf("expected the domain status, got %v", reg.Status)
+	}
+
+	if _, err := c.Lookup(context.Background(), "missing.test"); !errors.Is(err, ErrRDAPNotFound) {
+		t.Errorf("expected ErrRDAPNotFound, got %v", err)
+	}
+	if _, err := c.Lookup(context.Background(), "broken.test"); err == nil {
+		t.Error("expected an error for a malformed response")
+	}
+	before := atomic.LoadInt32(&hits)
+	if _, err := c.Lookup(context.Background(), "example.invalid"); !errors.Is(err, ErrNoRDAPServer) {
+		t.Errorf("expected ErrNoRDAPServer, got %v", err)
+	}
+	if atomic.LoadInt32(&hits) != before {
+		t.Error("expected no request for a tld without a server")
+	}
+}
+
+func TestRDAP_RateLimitsPerRegistry(t *testing.T) {
+	var hits int32
+	srv := rdapServer(t, &hits)
+	c := NewRDAP(Bootstrap{"test": srv.URL + "/rdap/"}, srv.Client(), "TestBot/1.0", 5)
+
+	start := time.Now()
+	for _, d := range []string{"example.test", "other.test", "example.test"} {
+		if _, err := c.Lookup(context.Background(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("expected 3 lookups at 5 qps to take at least 400ms, took %v", elapsed)
+	}
+}
+
+func TestRDAP_HonoursRetryAfter(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, exampleRDAP)
+	}))
+	defer srv.Close()
+	c := NewRDAP(Bootstrap{"test": srv.URL + "/rdap/"}, srv.Client(), "TestBot/1.0", 100)
+
+	start := time.Now()
+	reg, err := c.Lookup(context.Background(), "example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.Registrar != "Example Registrar, Inc." {
+		t.Errorf("expected registration after retry, got %+v", reg)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("expected the retry to wait out Retry-After, took %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestRDAP_RateLimitedGivesUp(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	c := NewRDAP(Bootstrap{"test": srv.URL + "/rdap/"}, srv.Client(), "TestBot/1.0", 100)
+
+	if _, err := c.Lookup(context.Background(), "example.test"); !errors.Is(err, ErrRDAPRateLimited) {
+		t.Errorf("expected ErrRDAPRateLimited, got %v", err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Errorf("expected one retry, got %d requests", n)
+	}
+}
+
+func TestRDAP_BackoffRespectsContext(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	c := NewRDAP(Bootstrap{"test": srv.URL + "/rdap/"}, srv.Client(), "TestBot/1.0", 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Lookup(ctx, "example.test"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the backoff wait to end with the context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected Lookup to return with its context, took %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("expected no request during the backoff, got %d", n)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"30":                            30 * time.Second,
+		"":                              defaultRetryAfter,
+		"soon":                          defaultRetryAfter,
+		"Mon, 01 Jan 2024 00:02:00 GMT": 2 * time.Minute,
+		"Sun, 31 Dec 2023 23:00:00 GMT": 0,
+		"86400":                         maxRetryAfter,
+	}
+	for v, want := range cases {
+		if got := retryAfter(v, now).Sub(now); got != want {
+			t.Errorf("retryAfter(%q): expected %v, got %v", v, want, got)
+		}
+	}
+}
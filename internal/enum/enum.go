@@ -0,0 +1,147 @@
+package enum
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gustycube/spyder/internal/dns"
+	"golang.org/x/time/rate"
+)
+
+// DefaultWords are labels common enough to be worth trying under any apex.
+var DefaultWords = []string{
+	"www", "mail", "smtp", "imap", "pop", "webmail", "mx", "ns1", "ns2",
+	"api", "app", "apps", "admin", "portal", "login", "sso", "auth", "id",
+	"dev", "staging", "stage", "test", "qa", "uat", "beta", "demo", "sandbox",
+	"cdn", "static", "assets", "img", "images", "media", "files", "download",
+	"blog", "shop", "store", "docs", "help", "support", "status", "news",
+	"vpn", "remote", "gateway", "git", "gitlab", "jenkins", "ci", "jira",
+	"m", "mobile", "secure", "intranet", "internal", "corp", "autodiscover",
+}
+
+// permutationAffixes are joined to each word when permutations are on.
+var permutationAffixes = []string{"dev", "staging", "test", "prod", "api", "old", "new", "1", "2"}
+
+// LoadWordlist reads one label per line, ignoring blanks and # comments.
+func LoadWordlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+	var words []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		w := strings.ToLower(strings.TrimSpace(sc.Text()))
+		if w == "" || strings.HasPrefix(w, "#") { continue }
+		words = append(words, w)
+	}
+	return words, sc.Err()
+}
+
+// Labels returns the distinct labels to try: each word and, with permute,
+// word-affix, affix-word and word+digit variants.
+func Labels(words []string, permute bool) []string {
+	seen := make(map[string]bool)
+	var out []string
+	add := func(l string) {
+		if l != "" && !seen[l] { seen[l] = true; out = append(out, l) }
+	}
+	for _, w := range words { add(w) }
+	if permute {
+		for _, w := range words {
+			for _, a := range permutationAffixes {
+				if a == w { continue }
+				if a[0] >= '0' && a[0] <= '9' { add(w + a); continue }
+				add(w + "-" + a)
+				add(a + "-" + w)
+			}
+		}
+	}
+	return out
+}
+
+// Options tunes an Enumerator.
+type Options struct {
+	// Words are the labels to try; nil uses DefaultWords.
+	Words []string
+	// Permute adds affixed variants of every word.
+	Permute bool
+	// QPS bounds DNS queries per second across all apexes; 0 means 50.
+	QPS float64
+	// Workers resolve candidates in parallel; 0 means 8.
+	Workers int
+}
+
+// Enumerator finds subdomains of an apex by resolving candidate labels.
+type Enumerator struct {
+	resolver *dns.Resolver
+	labels   []string
+	lim      *rate.Limiter
+	workers  int
+}
+
+// New returns an Enumerator resolving through resolver.
+func New(resolver *dns.Resolver, opts Options) *Enumerator {
+	words := opts.Words
+	if words == nil { words = DefaultWords }
+	if opts.QPS <= 0 { opts.QPS = 50 }
+	if opts.Workers <= 0 { opts.Workers = 8 }
+	return &Enumerator{
+		resolver: resolver,
+		labels:   Labels(words, opts.Permute),
+		lim:      rate.NewLimiter(rate.Limit(opts.QPS), opts.Workers),
+		workers:  opts.Workers,
+	}
+}
+
+// Enumerate returns the sorted subdomains of apex that resolve to at least
+// one address the zone's wildcard does not also answer with. It returns
+// nil without trying any label when whether apex is a wildcard zone
+// cannot be told, since every label of a wildcard zone would resolve.
+func (e *Enumerator) Enumerate(ctx context.Context, apex string) []string {
+	wc := e.resolver.Wildcard(ctx, apex)
+	if !wc.Known { return nil }
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var found []string
+	var wg sync.WaitGroup
+	for i := 0; i < e.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range jobs {
+				if e.resolves(ctx, host, wc) {
+					mu.Lock()
+					found = append(found, host)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, l := range e.labels {
+		if ctx.Err() != nil { break }
+		jobs <- l + "." + apex
+	}
+	close(jobs)
+	wg.Wait()
+	sort.Strings(found)
+	return found
+}
+
+// resolves looks host up with an A query and, if the name exists without
+// IPv4 addresses, an AAAA query.
+func (e *Enumerator) resolves(ctx context.Context, host string, wc dns.Wildcard) bool {
+	for _, ipv6 := range []bool{false, true} {
+		if e.lim.Wait(ctx) != nil { return false }
+		ips, st := e.resolver.LookupAddrs(ctx, host, ipv6)
+		if st == dns.StatusNXDomain { return false }
+		for _, ip := range ips {
+			if !wc.Matches(ip) { return true }
+		}
+		if len(ips) > 0 { return false }
+	}
+	return false
+}
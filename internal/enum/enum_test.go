@@ -0,0 +1,98 @@
+package enum
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gustycube/spyder/internal/dns"
+	"github.com/gustycube/spyder/internal/dns/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// zoneServer serves an A record for each of hosts, and any address under a
+// zone in wildcard, from a dnstest.Server.
+func zoneServer(t *testing.T, hosts map[string][4]byte, wildcard map[string][4]byte) *dns.Resolver {
+	t.Helper()
+	s := &dnstest.Server{Records: map[dnstest.Key][]dnsmessage.Resource{}, Wildcard: map[string][4]byte{}}
+	for h, ip := range hosts {
+		s.Records[dnstest.Key{Name: h + ".", Type: dnsmessage.TypeA}] = []dnsmessage.Resource{dnstest.RR(h+".", &dnsmessage.AResource{A: ip})}
+	}
+	for zone, ip := range wildcard {
+		s.Wildcard[zone+"."] = ip
+	}
+	return dns.NewResolver([]string{s.Start(t)})
+}
+
+func TestLabels(t *testing.T) {
+	got := Labels([]string{"www", "api", "www"}, false)
+	if strings.Join(got, ",") != "www,api" {
+		t.Errorf("expected distinct words, got %v", got)
+	}
+	perm := Labels([]string{"api"}, true)
+	want := map[string]bool{"api": true, "api-dev": true, "dev-api": true, "api1": true, "api-api": false}
+	have := map[string]bool{}
+	for _, l := range perm {
+		have[l] = true
+	}
+	for l, ok := range want {
+		if have[l] != ok {
+			t.Errorf("expected %s present=%v in %v", l, ok, perm)
+		}
+	}
+}
+
+func TestEnumerate(t *testing.T) {
+	r := zoneServer(t, map[string][4]byte{
+		"www.example.com":     {192, 0, 2, 1},
+		"api-dev.example.com": {192, 0, 2, 2},
+		"mail.example.com":    {192, 0, 2, 3},
+	}, nil)
+	e := New(r, Options{Words: []string{"www", "api", "mail", "vpn"}, Permute: true, QPS: 1000})
+
+	got := e.Enumerate(context.Background(), "example.com")
+	want := []string{"api-dev.example.com", "mail.example.com", "www.example.com"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestEnumerate_Wildcard(t *testing.T) {
+	r := zoneServer(t, map[string][4]byte{
+		"shop.wild.test": {192, 0, 2, 50},
+	}, map[string][4]byte{"wild.test": {198, 51, 100, 7}})
+	e := New(r, Options{Words: []string{"www", "shop", "dev"}, QPS: 1000})
+
+	got := e.Enumerate(context.Background(), "wild.test")
+	if len(got) != 1 || got[0] != "shop.wild.test" {
+		t.Errorf("expected only the host with its own address, got %v", got)
+	}
+}
+
+func TestEnumerate_WildcardUnknown(t *testing.T) {
+	s := &dnstest.Server{
+		Records: map[dnstest.Key][]dnsmessage.Resource{
+			{Name: "www.flaky.test.", Type: dnsmessage.TypeA}: {dnstest.RR("www.flaky.test.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})},
+		},
+		ZoneRCodes: map[string]dnsmessage.RCode{"flaky.test.": dnsmessage.RCodeServerFailure},
+	}
+	e := New(dns.NewResolver([]string{s.Start(t)}), Options{Words: []string{"www", "dev"}, QPS: 1000})
+
+	if got := e.Enumerate(context.Background(), "flaky.test"); got != nil {
+		t.Errorf("expected no enumeration while the wildcard is unknown, got %v", got)
+	}
+}
+
+func TestLoadWordlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	os.WriteFile(path, []byte("# labels\nWWW\n\n  api \n"), 0o644)
+	words, err := LoadWordlist(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(words, ",") != "www,api" {
+		t.Errorf("expected [www api], got %v", words)
+	}
+}
@@ -1,8 +1,12 @@
 package extract
 
 import (
+	"encoding/json"
 	"io"
+	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 
 	"golang.org/x/net/html"
@@ -15,43 +19,185 @@ func Apex(host string) string {
 	return h
 }
 
-func ParseLinks(base *url.URL, body io.Reader) ([]string, error) {
+// Link is a URL referenced by a page together with where it was found.
+// Element is the lower-case tag name and Attr the attribute it came from;
+// URLs found in inline <script> or <style> text have an empty Attr. Parent
+// is the enclosing picture, video or audio element of a <source>.
+type Link struct {
+	URL     string
+	Element string
+	Attr    string
+	Rel     string
+	Parent  string
+}
+
+var (
+	inlineURLRe = regexp.MustCompile(`https?://[^\s"'<>\\)]+`)
+	cssURLRe    = regexp.MustCompile(`url\(\s*['"]?([^'")\s]+)|@import\s+['"]([^'"]+)`)
+)
+
+// ParseLinks returns every URL referenced by the HTML document, resolved
+// against base (or the document's own <base href>).
+func ParseLinks(base *url.URL, body io.Reader) ([]Link, error) {
 	z := html.NewTokenizer(body)
-	var out []string
+	var out []Link
+	var inline, media string
+	add := func(el, attr, rel, raw string) {
+		l := Link{Element: el, Attr: attr, Rel: rel}
+		if el == "source" { l.Parent = media }
+		if l.URL = resolve(base, raw); l.URL != "" { out = append(out, l) }
+	}
 	for {
 		tt := z.Next()
-		if tt == html.ErrorToken {
+		switch tt {
+		case html.ErrorToken:
 			if z.Err() == io.EOF { return out, nil }
 			return out, z.Err()
-		}
-		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+		case html.EndTagToken:
+			inline = ""
+			if name, _ := z.TagName(); string(name) == media { media = "" }
+		case html.TextToken:
+			if inline == "" { continue }
+			text := string(z.Text())
+			if inline == "style" {
+				for _, m := range cssURLRe.FindAllStringSubmatch(text, -1) { add("style", "", "", m[1]+m[2]) }
+			} else {
+				for _, m := range inlineURLRe.FindAllString(text, -1) { add("script", "", "", m) }
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
 			t := z.Token()
-			switch strings.ToLower(t.Data) {
-			case "a", "link":
-				for _, a := range t.Attr {
-					if strings.EqualFold(a.Key, "href") {
-						u, err := url.Parse(strings.TrimSpace(a.Val)); if err == nil {
-							if u.Scheme == "" { u.Scheme = base.Scheme }
-							if u.Host == "" { u.Host = base.Host }
-							out = append(out, u.String())
-						}
-					}
+			el := strings.ToLower(t.Data)
+			attrs := make(map[string]string, len(t.Attr))
+			for _, a := range t.Attr { attrs[strings.ToLower(a.Key)] = a.Val }
+			rel := strings.ToLower(strings.TrimSpace(attrs["rel"]))
+			switch el {
+			case "picture", "video", "audio":
+				if tt == html.StartTagToken { media = el }
+			}
+			switch el {
+			case "base":
+				if v, ok := attrs["href"]; ok {
+					if u, err := url.Parse(resolve(base, v)); err == nil && u.Host != "" { base = u }
 				}
-			case "script", "img", "iframe", "source":
-				for _, a := range t.Attr {
-					if strings.EqualFold(a.Key, "src") {
-						u, err := url.Parse(strings.TrimSpace(a.Val)); if err == nil {
-							if u.Scheme == "" { u.Scheme = base.Scheme }
-							if u.Host == "" { u.Host = base.Host }
-							out = append(out, u.String())
-						}
-					}
+			case "a", "area", "link":
+				if v, ok := attrs["href"]; ok { add(el, "href", rel, v) }
+			case "script", "img", "iframe", "frame", "source", "embed", "audio", "video", "track", "input":
+				if v, ok := attrs["src"]; ok { add(el, "src", rel, v) }
+			case "object":
+				if v, ok := attrs["data"]; ok { add(el, "data", rel, v) }
+			case "form":
+				if v, ok := attrs["action"]; ok { add(el, "action", rel, v) }
+			case "meta":
+				if strings.EqualFold(attrs["http-equiv"], "refresh") {
+					if v := refreshURL(attrs["content"]); v != "" { add(el, "content", rel, v) }
 				}
 			}
+			if v, ok := attrs["srcset"]; ok {
+				for _, c := range srcsetURLs(v) { add(el, "srcset", rel, c) }
+			}
+			if v, ok := attrs["style"]; ok {
+				for _, m := range cssURLRe.FindAllStringSubmatch(v, -1) { add(el, "style", rel, m[1]+m[2]) }
+			}
+			if tt == html.StartTagToken && ((el == "script" && attrs["src"] == "") || el == "style") { inline = el }
 		}
 	}
 }
 
+// EdgeType classifies the link into the edge type emitted for it:
+// LOADS_SCRIPT, LOADS_STYLESHEET, EMBEDS_IFRAME, LOADS_IMAGE or LINKS_TO.
+// A <source> is an image only inside <picture>; in <video> or <audio> it
+// is media and stays LINKS_TO.
+func (l Link) EdgeType() string {
+	switch {
+	case l.Element == "script" && l.Attr == "src":
+		return "LOADS_SCRIPT"
+	case l.Element == "link" && l.Attr == "href" && hasToken(l.Rel, "modulepreload"):
+		return "LOADS_SCRIPT"
+	case l.Element == "link" && l.Attr == "href" && hasToken(l.Rel, "stylesheet"):
+		return "LOADS_STYLESHEET"
+	case (l.Element == "iframe" || l.Element == "frame") && l.Attr == "src":
+		return "EMBEDS_IFRAME"
+	case (l.Element == "img" || l.Element == "input" || (l.Element == "source" && l.Parent == "picture")) && (l.Attr == "src" || l.Attr == "srcset"):
+		return "LOADS_IMAGE"
+	}
+	return "LINKS_TO"
+}
+
+// ByEdgeType groups link URLs by their EdgeType.
+func ByEdgeType(links []Link) map[string][]string {
+	out := make(map[string][]string)
+	for _, l := range links { t := l.EdgeType(); out[t] = append(out[t], l.URL) }
+	return out
+}
+
+// EdgeTypes returns the keys of a ByEdgeType or HeaderLinks result in
+// sorted order, so edges come out in the same order on every run.
+func EdgeTypes(m map[string][]string) []string {
+	out := make([]string, 0, len(m))
+	for t := range m { out = append(out, t) }
+	sort.Strings(out)
+	return out
+}
+
+func hasToken(list, tok string) bool {
+	for _, f := range strings.Fields(list) { if f == tok { return true } }
+	return false
+}
+
+func resolve(base *url.URL, raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil { return "" }
+	return base.ResolveReference(u).String()
+}
+
+// srcsetURLs returns the image candidate URLs of a srcset attribute,
+// following the HTML candidate grammar: a URL runs to the next whitespace
+// (so data: URLs keep their commas) and descriptors run to the next comma
+// outside parentheses.
+func srcsetURLs(v string) []string {
+	var out []string
+	for i := 0; i < len(v); {
+		for i < len(v) && (isSpace(v[i]) || v[i] == ',') { i++ }
+		start := i
+		for i < len(v) && !isSpace(v[i]) { i++ }
+		u := v[start:i]
+		if strings.HasSuffix(u, ",") {
+			// no descriptors; the commas end the candidate
+			u = strings.TrimRight(u, ",")
+		} else {
+			for depth := 0; i < len(v) && (v[i] != ',' || depth > 0); i++ {
+				switch v[i] {
+				case '(':
+					depth++
+				case ')':
+					if depth > 0 { depth-- }
+				}
+			}
+		}
+		if u != "" { out = append(out, u) }
+	}
+	return out
+}
+
+// refreshURL extracts the target of a meta refresh such as "5; url=/next",
+// tolerating the variants browsers accept: a comma or no separator after
+// the delay, spaces around "=", a missing "url=" and quoted URLs.
+func refreshURL(content string) string {
+	s := strings.TrimLeft(content, " \t\n\f\r")
+	s = strings.TrimLeft(s, "0123456789.")
+	s = strings.TrimLeft(s, " \t\n\f\r")
+	if s != "" && (s[0] == ';' || s[0] == ',') { s = strings.TrimLeft(s[1:], " \t\n\f\r") }
+	if len(s) >= 3 && strings.EqualFold(s[:3], "url") {
+		if rest := strings.TrimLeft(s[3:], " \t\n\f\r"); strings.HasPrefix(rest, "=") { s = strings.TrimLeft(rest[1:], " \t\n\f\r") }
+	}
+	if s != "" && (s[0] == '\'' || s[0] == '"') {
+		if end := strings.IndexByte(s[1:], s[0]); end >= 0 { s = s[1 : end+1] } else { s = s[1:] }
+	}
+	return strings.TrimSpace(s)
+}
+
+func isSpace(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r' }
+
 func ExternalDomains(baseHost string, urls []string) []string {
 	baseApex := Apex(baseHost)
 	seen := make(map[string]struct{})
@@ -65,3 +211,136 @@ func ExternalDomains(baseHost string, urls []string) []string {
 	}
 	return out
 }
+
+// HeaderLinks collects URLs that response headers declare as dependencies,
+// keyed by the edge type they imply: CSP_ALLOWS for CSP source lists,
+// REPORTS_TO for report-uri, Report-To and Reporting-Endpoints, PRELOADS for
+// Link preload/preconnect hints and CORS_ALLOWS for Access-Control-Allow-Origin.
+func HeaderLinks(base *url.URL, h http.Header) map[string][]string {
+	out := make(map[string][]string)
+	add := func(typ, u string) {
+		if u != "" { out[typ] = append(out[typ], u) }
+	}
+	for _, name := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+		for _, v := range h.Values(name) {
+			// several policies may share one header, separated by commas
+			for _, dir := range strings.FieldsFunc(v, func(r rune) bool { return r == ';' || r == ',' }) {
+				f := strings.Fields(dir)
+				if len(f) < 2 { continue }
+				switch d := strings.ToLower(f[0]); {
+				case d == "report-uri":
+					for _, v := range f[1:] { add("REPORTS_TO", headerURL(base, v)) }
+				case strings.HasSuffix(d, "-src") || d == "form-action" || d == "frame-ancestors":
+					for _, v := range f[1:] { add("CSP_ALLOWS", cspSource(base, v)) }
+				}
+			}
+		}
+	}
+	for _, v := range h.Values("Report-To") {
+		// Report-To is a comma-separated list of JSON objects
+		dec := json.NewDecoder(strings.NewReader("[" + v + "]"))
+		var groups []struct{ Endpoints []struct{ URL string `json:"url"` } `json:"endpoints"` }
+		if dec.Decode(&groups) != nil { continue }
+		for _, g := range groups {
+			for _, ep := range g.Endpoints { add("REPORTS_TO", headerURL(base, ep.URL)) }
+		}
+	}
+	for _, v := range h.Values("Reporting-Endpoints") {
+		// a structured-field dictionary: name="url";param, ...
+		for _, member := range splitHeader(v, ',') {
+			if _, val, ok := strings.Cut(member, "="); ok { add("REPORTS_TO", headerURL(base, unquote(splitHeader(val, ';')[0]))) }
+		}
+	}
+	for _, v := range h.Values("Link") {
+		for _, lv := range splitHeader(v, ',') {
+			target, params, ok := strings.Cut(lv, ">")
+			if !strings.HasPrefix(target, "<") || !ok { continue }
+			for _, param := range splitHeader(params, ';') {
+				k, val, _ := strings.Cut(param, "=")
+				if !strings.EqualFold(strings.TrimSpace(k), "rel") { continue }
+				if preloadRel(unquote(val)) { add("PRELOADS", headerURL(base, target[1:])) }
+				break // only the first rel counts (RFC 8288 3.3)
+			}
+		}
+	}
+	for _, v := range h.Values("Access-Control-Allow-Origin") { add("CORS_ALLOWS", headerURL(base, v)) }
+	return out
+}
+
+func preloadRel(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		switch r {
+		case "preload", "modulepreload", "prefetch", "preconnect", "dns-prefetch":
+			return true
+		}
+	}
+	return false
+}
+
+// splitHeader splits a header value on sep, except inside quoted strings
+// and <URI-references>, trimming each part and dropping empty ones.
+func splitHeader(v string, sep byte) []string {
+	var out []string
+	quoted, bracketed, start := false, false, 0
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"' && !bracketed:
+			quoted = !quoted
+		case c == '<' && !quoted:
+			bracketed = true
+		case c == '>' && !quoted:
+			bracketed = false
+		case c == sep && !quoted && !bracketed:
+			if p := strings.TrimSpace(v[start:i]); p != "" { out = append(out, p) }
+			start = i + 1
+		}
+	}
+	if p := strings.TrimSpace(v[start:]); p != "" { out = append(out, p) }
+	if out == nil { out = []string{""} }
+	return out
+}
+
+// unquote strips a quoted-string's quotes and backslash escapes; tokens are
+// returned trimmed.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' { return s }
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 { i++ }
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// headerURL resolves a header-declared URI reference against base, or
+// returns "" for the "*" and "null" origins.
+func headerURL(base *url.URL, raw string) string {
+	s := strings.TrimSpace(raw)
+	if s == "" || s == "*" || s == "null" { return "" }
+	return resolve(base, s)
+}
+
+// cspSource turns a CSP host-source ([scheme://]host[:port][/path]) into an
+// absolute URL, or "" for keywords, nonces, hashes, scheme-only sources and
+// wildcards that name no host. A leading "*." and a "*" port are dropped.
+func cspSource(base *url.URL, src string) string {
+	s := strings.TrimSpace(src)
+	if s == "" || strings.HasPrefix(s, "'") { return "" }
+	scheme := base.Scheme
+	if i := strings.Index(s, "://"); i >= 0 {
+		scheme, s = strings.ToLower(s[:i]), s[i+3:]
+	} else if strings.HasSuffix(s, ":") {
+		return ""
+	}
+	host, path := s, ""
+	if i := strings.IndexByte(s, '/'); i >= 0 { host, path = s[:i], s[i:] }
+	if h, port, ok := strings.Cut(host, ":"); ok && port == "*" { host = h }
+	host = strings.TrimPrefix(host, "*.")
+	if host == "" || strings.Contains(host, "*") { return "" }
+	u, err := url.Parse(scheme + "://" + host + path)
+	if err != nil { return "" }
+	return u.String()
+}
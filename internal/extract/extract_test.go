@@ -0,0 +1,228 @@
+package extract
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	base := &url.URL{Scheme: "https", Host: "example.com", Path: "/"}
+	doc := `<html><head>
+<base href="https://static.example.com/assets/">
+<meta http-equiv="refresh" content="5; url=https://moved.example.net/">
+<link rel="canonical" href="https://www.example.com/">
+<link rel="dns-prefetch" href="//fonts.googleapis.com">
+<style>body { background: url('https://img.cdn.net/bg.png'); } @import "https://css.cdn.net/x.css";</style>
+<script>window.ga = "https://www.google-analytics.com/analytics.js";</script>
+</head><body>
+<a href="/about">About</a>
+<img src="logo.png" srcset="https://i1.cdn.net/a.png 1x, https://i2.cdn.net/a.png 2x">
+<form action="https://forms.partner.io/submit"></form>
+<object data="https://media.host.org/movie.swf"></object>
+<div style="background-image:url(https://bg.cdn.net/d.jpg)"></div>
+</body></html>`
+
+	links, err := ParseLinks(base, strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []Link{
+		{URL: "https://moved.example.net/", Element: "meta", Attr: "content"},
+		{URL: "https://www.example.com/", Element: "link", Attr: "href", Rel: "canonical"},
+		{URL: "https://fonts.googleapis.com", Element: "link", Attr: "href", Rel: "dns-prefetch"},
+		{URL: "https://img.cdn.net/bg.png", Element: "style"},
+		{URL: "https://css.cdn.net/x.css", Element: "style"},
+		{URL: "https://www.google-analytics.com/analytics.js", Element: "script"},
+		{URL: "https://static.example.com/about", Element: "a", Attr: "href"},
+		{URL: "https://static.example.com/assets/logo.png", Element: "img", Attr: "src"},
+		{URL: "https://i1.cdn.net/a.png", Element: "img", Attr: "srcset"},
+		{URL: "https://i2.cdn.net/a.png", Element: "img", Attr: "srcset"},
+		{URL: "https://forms.partner.io/submit", Element: "form", Attr: "action"},
+		{URL: "https://media.host.org/movie.swf", Element: "object", Attr: "data"},
+		{URL: "https://bg.cdn.net/d.jpg", Element: "div", Attr: "style"},
+	}
+	if len(links) != len(want) {
+		t.Fatalf("expected %d links, got %d: %+v", len(want), len(links), links)
+	}
+	for i := range want {
+		if links[i] != want[i] {
+			t.Errorf("link %d: expected %+v, got %+v", i, want[i], links[i])
+		}
+	}
+}
+
+func TestExternalDomains(t *testing.T) {
+	urls := []string{
+		"https://www.example.com/a",
+		"https://cdn.example.com/b",
+		"https://cdn.other.net/c",
+		"https://CDN.other.net/d",
+		"mailto:someone@example.org",
+		"https://partner.co.uk/",
+	}
+	got := ExternalDomains("example.com", urls)
+	want := []string{"cdn.other.net", "partner.co.uk"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestHeaderLinks(t *testing.T) {
+	base := &url.URL{Scheme: "https", Host: "www.example.com", Path: "/"}
+	h := http.Header{}
+	h.Set("Content-Security-Policy", "default-src 'self'; script-src 'self' https://cdn.jsdelivr.net *.googletagmanager.com data:; connect-src api.segment.io:443; report-uri https://o1.ingest.sentry.io/api/1/security/")
+	h.Set("Report-To", `{"group":"default","max_age":31536000,"endpoints":[{"url":"https://a.nel.cloudflare.com/report"}]}, {"group":"csp","endpoints":[{"url":"https://csp.report-uri.com/r"}]}`)
+	h.Set("Link", `<https://fonts.gstatic.com>; rel=preconnect, </app.js>; rel=preload; as=script, <https://example.org/about>; rel="author"`)
+	h.Set("Access-Control-Allow-Origin", "https://app.partner.io")
+
+	got := HeaderLinks(base, h)
+
+	want := map[string][]string{
+		"CSP_ALLOWS":  {"api.segment.io", "cdn.jsdelivr.net", "googletagmanager.com"},
+		"REPORTS_TO":  {"a.nel.cloudflare.com", "csp.report-uri.com", "o1.ingest.sentry.io"},
+		"PRELOADS":    {"fonts.gstatic.com"},
+		"CORS_ALLOWS": {"app.partner.io"},
+	}
+	for typ, hosts := range want {
+		ext := ExternalDomains(base.Host, got[typ])
+		sort.Strings(ext)
+		if len(ext) != len(hosts) {
+			t.Errorf("%s: expected %v, got %v", typ, hosts, ext)
+			continue
+		}
+		for i := range hosts {
+			if ext[i] != hosts[i] {
+				t.Errorf("%s: expected %v, got %v", typ, hosts, ext)
+				break
+			}
+		}
+	}
+}
+
+func TestHeaderLinks_IgnoresKeywords(t *testing.T) {
+	base := &url.URL{Scheme: "https", Host: "example.com", Path: "/"}
+	h := http.Header{}
+	h.Set("Content-Security-Policy", "default-src 'none'; img-src * blob: https:")
+	h.Set("Access-Control-Allow-Origin", "*")
+
+	got := HeaderLinks(base, h)
+	if len(got) != 0 {
+		t.Errorf("expected no header links, got %v", got)
+	}
+}
+
+func TestLinkEdgeType(t *testing.T) {
+	tests := []struct {
+		link Link
+		want string
+	}{
+		{Link{Element: "script", Attr: "src"}, "LOADS_SCRIPT"},
+		{Link{Element: "link", Attr: "href", Rel: "modulepreload"}, "LOADS_SCRIPT"},
+		{Link{Element: "link", Attr: "href", Rel: "alternate stylesheet"}, "LOADS_STYLESHEET"},
+		{Link{Element: "iframe", Attr: "src"}, "EMBEDS_IFRAME"},
+		{Link{Element: "img", Attr: "srcset"}, "LOADS_IMAGE"},
+		{Link{Element: "source", Attr: "srcset", Parent: "picture"}, "LOADS_IMAGE"},
+		{Link{Element: "source", Attr: "src", Parent: "video"}, "LINKS_TO"},
+		{Link{Element: "a", Attr: "href"}, "LINKS_TO"},
+		{Link{Element: "link", Attr: "href", Rel: "canonical"}, "LINKS_TO"},
+		{Link{Element: "script"}, "LINKS_TO"},
+	}
+	for _, tt := range tests {
+		if got := tt.link.EdgeType(); got != tt.want {
+			t.Errorf("EdgeType(%+v) = %s, want %s", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderLinks_Grammar(t *testing.T) {
+	base := &url.URL{Scheme: "https", Host: "example.com", Path: "/"}
+	h := http.Header{}
+	h.Set("Link", `<https://cdn.example.net/a,b.css>; rel=preload; title="x, y; z", <https://img.example.org/>; title="a,b"; rel="preconnect dns-prefetch", <https://other.example.io/>; rel="author"; rel=preload`)
+	h.Set("Content-Security-Policy", "img-src assets.example.net:* https://*.static.example.org:8443/img/ 'nonce-abc'; default-src 'self', script-src js.example.io")
+	h.Set("Reporting-Endpoints", `main="https://reports.example.net/a,b";x=1, other="/r"`)
+
+	got := HeaderLinks(base, h)
+	want := map[string][]string{
+		"PRELOADS":   {"https://cdn.example.net/a,b.css", "https://img.example.org/"},
+		"CSP_ALLOWS": {"https://assets.example.net", "https://static.example.org:8443/img/", "https://js.example.io"},
+		"REPORTS_TO": {"https://reports.example.net/a,b", "https://example.com/r"},
+	}
+	for typ, urls := range want {
+		if strings.Join(got[typ], " ") != strings.Join(urls, " ") {
+			t.Errorf("%s: expected %v, got %v", typ, urls, got[typ])
+		}
+	}
+}
+
+func TestSrcsetURLs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"a.png 1x, b.png 2x", []string{"a.png", "b.png"}},
+		{"data:image/png;base64,AAAA 1x, /img/b,c.png 2x", []string{"data:image/png;base64,AAAA", "/img/b,c.png"}},
+		{"a.png, b.png 2x", []string{"a.png", "b.png"}},
+		{" a.png (min-width: 1px, max-width: 2px) 100w , b.png", []string{"a.png", "b.png"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := srcsetURLs(tt.in); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("srcsetURLs(%q): expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestRefreshURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"5; url=/next", "/next"},
+		{"0;URL = 'https://example.com/a b'", "https://example.com/a b"},
+		{`3, url="/q?x=1;y=2"`, "/q?x=1;y=2"},
+		{"0; /plain", "/plain"},
+		{"  1.5 url=/x", "/x"},
+		{"10", ""},
+	}
+	for _, tt := range tests {
+		if got := refreshURL(tt.in); got != tt.want {
+			t.Errorf("refreshURL(%q): expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestParseLinks_SourceParent(t *testing.T) {
+	base := &url.URL{Scheme: "https", Host: "example.com", Path: "/"}
+	doc := `<picture><source srcset="/a.webp"><img src="/a.png"></picture>
+<video controls><source src="/v.mp4" type="video/mp4"><track src="/v.vtt"></video>
+<audio><source src="/s.ogg"></audio><source src="/stray.png">`
+
+	links, err := ParseLinks(base, strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"/a.webp": "picture", "/v.mp4": "video", "/s.ogg": "audio", "/stray.png": ""}
+	for _, l := range links {
+		if l.Element != "source" {
+			continue
+		}
+		u, _ := url.Parse(l.URL)
+		if parent, ok := want[u.Path]; !ok || parent != l.Parent {
+			t.Errorf("%s: expected parent %q, got %q", u.Path, parent, l.Parent)
+		}
+		delete(want, u.Path)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing sources: %v", want)
+	}
+}
+
+func TestEdgeTypes(t *testing.T) {
+	m := map[string][]string{"LOADS_SCRIPT": nil, "CSP_ALLOWS": nil, "LINKS_TO": nil, "EMBEDS_IFRAME": nil}
+	for i := 0; i < 5; i++ {
+		if got := strings.Join(EdgeTypes(m), ","); got != "CSP_ALLOWS,EMBEDS_IFRAME,LINKS_TO,LOADS_SCRIPT" {
+			t.Fatalf("expected sorted edge types, got %s", got)
+		}
+	}
+}
@@ -7,9 +7,16 @@ import (
 	"time"
 
 	"github.com/gustycube/spyder/internal/circuitbreaker"
+	"github.com/gustycube/spyder/internal/egress"
 )
 
 func Default() *http.Client {
+	return New(nil)
+}
+
+// New returns the default probe client sending its traffic through the
+// egress d, or directly when d is nil.
+func New(d *egress.Dialer) *http.Client {
 	tr := &http.Transport{
 		TLSClientConfig:       &tls.Config{InsecureSkipVerify: false},
 		DisableCompression:    false,
@@ -20,6 +27,10 @@ func Default() *http.Client {
 		IdleConnTimeout:       30 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
+	if d != nil {
+		tr.DialContext = d.DialContext
+		tr.Proxy = d.Proxy
+	}
 	return &http.Client{
 		Transport: tr,
 		Timeout:   15 * time.Second,
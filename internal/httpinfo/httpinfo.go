@@ -0,0 +1,51 @@
+package httpinfo
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gustycube/spyder/internal/emit"
+)
+
+// Observe builds an HTTP observation from a response. elapsed is the time
+// until response headers arrived and n the number of body bytes read, used
+// when the server did not declare a Content-Length.
+func Observe(host, requested string, resp *http.Response, elapsed time.Duration, n int64) emit.NodeHTTP {
+	h := resp.Header
+	final := requested
+	if resp.Request != nil && resp.Request.URL != nil {
+		final = resp.Request.URL.String()
+	}
+	length := resp.ContentLength
+	if length < 0 {
+		length = n
+	}
+	return emit.NodeHTTP{
+		Host:          host,
+		URL:           requested,
+		FinalURL:      final,
+		StatusCode:    resp.StatusCode,
+		Proto:         resp.Proto,
+		Server:        h.Get("Server"),
+		PoweredBy:     h.Get("X-Powered-By"),
+		HSTS:          h.Get("Strict-Transport-Security"),
+		CSP:           h.Get("Content-Security-Policy"),
+		ContentType:   h.Get("Content-Type"),
+		ContentLength: length,
+		ResponseMS:    elapsed.Milliseconds(),
+		ObservedAt:    time.Now().UTC(),
+	}
+}
+
+// CountingReader counts the bytes read through it.
+type CountingReader struct {
+	R io.Reader
+	N int64
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.R.Read(p)
+	c.N += int64(n)
+	return n, err
+}
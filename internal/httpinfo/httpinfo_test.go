@@ -0,0 +1,59 @@
+package httpinfo
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestObserve(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/home", http.StatusFound)
+			return
+		}
+		w.Header().Set("Server", "nginx/1.25")
+		w.Header().Set("X-Powered-By", "PHP/8.2")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cr := &CountingReader{R: resp.Body}
+	io.Copy(io.Discard, cr)
+	resp.Body.Close()
+
+	obs := Observe("example.com", server.URL+"/", resp, 25*time.Millisecond, cr.N)
+
+	if obs.StatusCode != 200 {
+		t.Errorf("expected status 200, got %d", obs.StatusCode)
+	}
+	if obs.FinalURL != server.URL+"/home" {
+		t.Errorf("expected final URL after redirect, got %s", obs.FinalURL)
+	}
+	if obs.Server != "nginx/1.25" || obs.PoweredBy != "PHP/8.2" {
+		t.Errorf("unexpected server headers: %q %q", obs.Server, obs.PoweredBy)
+	}
+	if obs.HSTS != "" {
+		t.Errorf("expected no HSTS, got %q", obs.HSTS)
+	}
+	if obs.CSP != "default-src 'self'" {
+		t.Errorf("unexpected CSP: %q", obs.CSP)
+	}
+	if obs.ContentLength != 13 {
+		t.Errorf("expected content length 13, got %d", obs.ContentLength)
+	}
+	if obs.ResponseMS != 25 {
+		t.Errorf("expected response time 25ms, got %d", obs.ResponseMS)
+	}
+	if obs.Proto != "HTTP/1.1" {
+		t.Errorf("expected HTTP/1.1, got %s", obs.Proto)
+	}
+}
@@ -12,10 +12,16 @@ var (
 	TasksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spyder_tasks_total", Help: "tasks processed"}, []string{"status"})
 	EdgesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spyder_edges_total", Help: "edges emitted"}, []string{"type"})
 	RobotsBlocks = prometheus.NewCounter(prometheus.CounterOpts{Name: "spyder_robots_blocked_total", Help: "robots.txt blocks"})
+	CertValidations = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spyder_cert_validations_total", Help: "certificate verification outcomes"}, []string{"status"})
+	RootFetches = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spyder_root_fetch_attempts_total", Help: "root fetch attempts by scheme:port candidate"}, []string{"candidate", "outcome"})
+	DNSResults = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spyder_dns_results_total", Help: "DNS query outcomes by record type"}, []string{"qtype", "status"})
+	DNSCache = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spyder_dns_cache_total", Help: "DNS cache lookups by result (hit, miss, coalesced)"}, []string{"result"})
+	AXFRAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spyder_axfr_attempts_total", Help: "zone transfer attempts by outcome"}, []string{"status"})
+	FindingsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "spyder_findings_total", Help: "findings emitted"}, []string{"kind"})
 )
 
 func init() {
-	prometheus.MustRegister(TasksTotal, EdgesTotal, RobotsBlocks)
+	prometheus.MustRegister(TasksTotal, EdgesTotal, RobotsBlocks, RootFetches, CertValidations, FindingsTotal, DNSResults, DNSCache, AXFRAttempts)
 }
 
 func Serve(addr string, log *zap.SugaredLogger) {
@@ -3,25 +3,105 @@ package probe
 import "go.opentelemetry.io/otel"
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gustycube/spyder/internal/dedup"
 	"github.com/gustycube/spyder/internal/dns"
+	"github.com/gustycube/spyder/internal/egress"
 	"github.com/gustycube/spyder/internal/emit"
+	"github.com/gustycube/spyder/internal/enrich"
+	"github.com/gustycube/spyder/internal/enum"
 	"github.com/gustycube/spyder/internal/extract"
 	"github.com/gustycube/spyder/internal/httpclient"
+	"github.com/gustycube/spyder/internal/httpinfo"
 	"github.com/gustycube/spyder/internal/rate"
 	"github.com/gustycube/spyder/internal/robots"
+	"github.com/gustycube/spyder/internal/takeover"
 	"github.com/gustycube/spyder/internal/tlsinfo"
 	"github.com/gustycube/spyder/internal/metrics"
+	"github.com/temoto/robotstxt"
 	"go.uber.org/zap"
 )
 
+// Options tunes how much work the probe does per host. A nil *Options
+// passed to New selects the defaults.
+type Options struct {
+	// MaxPages is the per-host page budget for the same-apex crawl.
+	MaxPages int
+	// RootCandidates are "scheme:port" pairs tried in order for the root
+	// fetch; the first one that answers is crawled.
+	RootCandidates []string
+	// Dialer carries all probe connections (HTTP, robots.txt, TLS); nil
+	// dials directly.
+	Dialer *egress.Dialer
+	// FetchEachIP additionally fetches the root page and certificate from
+	// every resolved IP, not just the first one that answers.
+	FetchEachIP bool
+	// TLSFingerprint runs the extra probe handshakes needed for a JARM-style
+	// fingerprint of each host's TLS stack.
+	TLSFingerprint bool
+	// MXCerts collects the STARTTLS certificate of every MX host.
+	MXCerts bool
+	// DefaultCerts handshakes with every resolved IP without SNI and
+	// records the certificate it falls back to when that differs from the
+	// host's own, exposing the default certificate of shared IPs.
+	DefaultCerts bool
+	// RootCAs verifies presented certificates; nil uses the system pool.
+	RootCAs *x509.CertPool
+	// Resolver answers every DNS query; nil uses the system name servers.
+	Resolver *dns.Resolver
+	// Takeover checks CNAME chains for dangling targets and unclaimed
+	// services, using TakeoverSignatures (nil: takeover.DefaultSignatures).
+	Takeover           bool
+	TakeoverSignatures []takeover.Signature
+	// ZoneRecords collects each apex's SOA, CAA, common SRV services and
+	// DNSSEC status once per run, and the PTR names of every address.
+	ZoneRecords bool
+	// SuppressWildcard drops RESOLVES_TO edges whose address only matches
+	// the zone's wildcard answer instead of marking them wildcard=true.
+	SuppressWildcard bool
+	// AXFR attempts a zone transfer from every name server of each apex,
+	// recording the outcome on its USES_NS edges and importing the zone
+	// when a server allows it.
+	AXFR bool
+	// Delegation queries the parent zone and every authoritative server of
+	// each apex directly, flagging lame servers, NS sets that differ from
+	// the parent's referral and SOA serial drift.
+	Delegation bool
+	// MMDB enriches every new address with its country, announced prefix
+	// and origin AS from local databases; nil disables enrichment.
+	MMDB *enrich.MMDB
+	// Providers attributes hosts and addresses to clouds and CDNs by their
+	// CNAME and NS targets and published IP ranges; nil disables it.
+	Providers *enrich.Classifier
+	// RDAP looks every apex up once per run at the registry its bootstrap
+	// names, at most RDAPQPS requests per second per registry (0: 1); nil
+	// disables registration lookups.
+	RDAP    enrich.Bootstrap
+	RDAPQPS float64
+	// Enum brute-forces subdomains of every apex seen and crawls the ones
+	// found; nil disables enumeration.
+	Enum *enum.Options
+}
+
+// DefaultOptions returns the options used when New is given nil.
+func DefaultOptions() *Options {
+	return &Options{MaxPages: 1, RootCandidates: []string{"https:443", "http:80"}}
+}
+
 type Probe struct {
 	ua       string
 	probeID  string
@@ -30,32 +110,125 @@ type Probe struct {
 	dedup    dedup.Interface
 	out      chan<- emit.Batch
 	hc       *httpclient.ResilientClient
+	hcPerIP  *httpclient.ResilientClient
+	dialer   *egress.Dialer
+	resolver *dns.Resolver
+	takeover *takeover.Detector
+	enum     *enum.Enumerator
+	rdap     *enrich.RDAP
+	regs     chan string // apexes awaiting RDAP, while Run is active
 	rob      *robots.Cache
 	ratelim  *rate.PerHost
+	opts     Options
 	log      *zap.SugaredLogger
 }
 
-func New(ua, probeID, runID string, excluded []string, d dedup.Interface, out chan<- emit.Batch, log *zap.SugaredLogger) *Probe {
-	baseClient := httpclient.Default()
+func New(ua, probeID, runID string, excluded []string, d dedup.Interface, out chan<- emit.Batch, opts *Options, log *zap.SugaredLogger) *Probe {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if opts.MaxPages < 1 {
+		opts.MaxPages = 1
+	}
+	if len(opts.RootCandidates) == 0 {
+		opts.RootCandidates = DefaultOptions().RootCandidates
+	}
+	dialer := opts.Dialer
+	if dialer == nil {
+		dialer, _ = egress.New(egress.Config{})
+	}
+	baseClient := httpclient.New(dialer)
 	hc := httpclient.NewResilientClient(baseClient)
+	// Per-IP fetches must not reuse a pooled connection to another IP
+	perIPClient := httpclient.New(dialer)
+	perIPClient.Transport.(*http.Transport).DisableKeepAlives = true
+	resolver := opts.Resolver
+	if resolver == nil {
+		resolver = dns.NewResolver(nil)
+	}
+	var detector *takeover.Detector
+	if opts.Takeover {
+		detector = takeover.New(opts.TakeoverSignatures, resolver)
+	}
+	var enumerator *enum.Enumerator
+	if opts.Enum != nil {
+		enumerator = enum.New(resolver, *opts.Enum)
+	}
+	var rdap *enrich.RDAP
+	if opts.RDAP != nil {
+		rdap = enrich.NewRDAP(opts.RDAP, baseClient, ua, opts.RDAPQPS)
+	}
 	return &Probe{
 		ua: ua, probeID: probeID, runID: runID, excluded: excluded, dedup: d, out: out,
-		hc: hc, rob: robots.NewCache(baseClient, ua), ratelim: rate.New(1.0, 1), log: log,
+		hc: hc, hcPerIP: httpclient.NewResilientClient(perIPClient), dialer: dialer, resolver: resolver, takeover: detector, enum: enumerator, rdap: rdap,
+		rob: robots.NewCache(baseClient, ua), ratelim: rate.New(1.0, 1), opts: *opts, log: log,
 	}
 }
 
+// rdapWorkers is how many registration lookups Run keeps in flight; each
+// registry is still held to its own rate.
+const rdapWorkers = 4
+
+// Run crawls tasks with the given number of workers until tasks is closed
+// and every subdomain enumerated along the way has been crawled. Enumerated
+// hosts go back into the shared queue rather than being crawled by the
+// worker that found them, and each host, seed or enumerated, is crawled at
+// most once per run. With RDAP on, registrations are looked up by
+// rdapWorkers workers of their own and Run also waits for those.
 func (p *Probe) Run(ctx context.Context, tasks <-chan string, workers int) {
+	type task struct {
+		host       string
+		enumerated bool
+	}
+	queue := make(chan task)
+	// pending counts queued and running crawls; a crawl's subdomains are
+	// added before it is done, so it only drops to zero once all are
+	var pending sync.WaitGroup
+	go func() {
+		for host := range tasks { pending.Add(1); queue <- task{host: host} }
+		pending.Wait()
+		close(queue)
+	}()
+	// registry lookups are slow and rate limited per registry, so they run
+	// beside the crawl rather than in it
+	var regs sync.WaitGroup
+	if p.rdap != nil {
+		p.regs = make(chan string, 1024)
+		for i := 0; i < rdapWorkers; i++ {
+			regs.Add(1)
+			go func() {
+				defer regs.Done()
+				for apex := range p.regs {
+					r := &results{now: time.Now().UTC(), host: apex}
+					p.register(ctx, r, apex)
+					p.flush(r)
+				}
+			}()
+		}
+	}
 	done := make(chan struct{})
 	for i := 0; i < workers; i++ {
 		go func() {
-			for host := range tasks {
-				p.CrawlOne(ctx, host)
-				metrics.TasksTotal.WithLabelValues("ok").Inc()
+			for t := range queue {
+				if !p.dedup.Seen(p.runKey("crawl|" + t.host)) {
+					p.CrawlOne(ctx, t.host)
+					status := "ok"
+					if t.enumerated { status = "enumerated" }
+					metrics.TasksTotal.WithLabelValues(status).Inc()
+					if subs := p.enumerate(ctx, t.host); len(subs) > 0 {
+						pending.Add(len(subs))
+						go func() {
+							for _, s := range subs { queue <- task{host: s, enumerated: true} }
+						}()
+					}
+				}
+				pending.Done()
 			}
 			done <- struct{}{}
 		}()
 	}
 	for i := 0; i < workers; i++ { <-done }
+	if p.regs != nil { close(p.regs); regs.Wait(); p.regs = nil }
 }
 
 func (p *Probe) CrawlOne(ctx context.Context, host string) {
@@ -63,83 +236,557 @@ func (p *Probe) CrawlOne(ctx context.Context, host string) {
 	ctx, span := tr.Start(ctx, "CrawlOne")
 	defer span.End()
 	now := time.Now().UTC()
-	var nodesD []emit.NodeDomain
-	var nodesIP []emit.NodeIP
-	var nodesC []emit.NodeCert
-	var edges []emit.Edge
+	r := &results{now: now, host: host}
 
 	ap := extract.Apex(host)
-	nodesD = append(nodesD, emit.NodeDomain{Host: host, Apex: ap, FirstSeen: now, LastSeen: now})
+	p.dedup.Seen("domain|" + host)
+	r.nodesD = append(r.nodesD, emit.NodeDomain{Host: host, Apex: ap, FirstSeen: now, LastSeen: now})
 
-	ips, ns, cname, mx, _ := dns.ResolveAll(ctx, host)
-	for _, ip := range ips {
-		if !p.dedup.Seen("nodeip|"+ip) { nodesIP = append(nodesIP, emit.NodeIP{IP: ip, FirstSeen: now, LastSeen: now}) }
-		k := "edge|"+host+"|RESOLVES_TO|"+ip
-		if !p.dedup.Seen(k) { edges = append(edges, emit.Edge{Type: "RESOLVES_TO", Source: host, Target: ip, ObservedAt: now, ProbeID: p.probeID, RunID: p.runID}); metrics.EdgesTotal.WithLabelValues("RESOLVES_TO").Inc() }
+	rec := p.resolver.Lookup(ctx, host)
+	r.nodesD[0].Status, r.nodesD[0].RCodes = rec.HostStatus(), rec.Status
+	for qt, st := range rec.Status { metrics.DNSResults.WithLabelValues(qt, st).Inc() }
+	// before the host's own edges so the apex's USES_NS carry AXFR results
+	p.collectZone(ctx, r, ap)
+	ips, mx := rec.IPs(), rec.MX
+	r.ips = ips
+	var wc dns.Wildcard
+	if host != ap && len(ips) > 0 { wc = p.resolver.Wildcard(ctx, ap) }
+	for _, ip := range rec.A { p.resolvesTo(r, host, ip, 4, wc.Matches(ip)) }
+	for _, ip := range rec.AAAA { p.resolvesTo(r, host, ip, 6, wc.Matches(ip)) }
+	for _, n := range rec.NS { p.linkDomain(r, "USES_NS", host, n) }
+	// one ALIAS_OF per hop so CDN and SaaS fronting stays visible
+	prev := host
+	for _, c := range rec.CNAMEs {
+		p.linkDomain(r, "ALIAS_OF", prev, c)
+		prev = c
 	}
-	for _, n := range ns {
-		if !p.dedup.Seen("domain|"+n) { nodesD = append(nodesD, emit.NodeDomain{Host: n, Apex: extract.Apex(n), FirstSeen: now, LastSeen: now}) }
-		k := "edge|"+host+"|USES_NS|"+n
-		if !p.dedup.Seen(k) { edges = append(edges, emit.Edge{Type: "USES_NS", Source: host, Target: n, ObservedAt: now, ProbeID: p.probeID, RunID: p.runID}); metrics.EdgesTotal.WithLabelValues("USES_NS").Inc() }
-	}
-	if cname != "" {
-		if !p.dedup.Seen("domain|"+cname) { nodesD = append(nodesD, emit.NodeDomain{Host: cname, Apex: extract.Apex(cname), FirstSeen: now, LastSeen: now}) }
-		k := "edge|"+host+"|ALIAS_OF|"+cname
-		if !p.dedup.Seen(k) { edges = append(edges, emit.Edge{Type: "ALIAS_OF", Source: host, Target: cname, ObservedAt: now, ProbeID: p.probeID, RunID: p.runID}) }
-	}
-	for _, m := range mx {
-		if !p.dedup.Seen("domain|"+m) { nodesD = append(nodesD, emit.NodeDomain{Host: m, Apex: extract.Apex(m), FirstSeen: now, LastSeen: now}) }
-		k := "edge|"+host+"|USES_MX|"+m
-		if !p.dedup.Seen(k) { edges = append(edges, emit.Edge{Type: "USES_MX", Source: host, Target: m, ObservedAt: now, ProbeID: p.probeID, RunID: p.runID}); metrics.EdgesTotal.WithLabelValues("USES_MX").Inc() }
+	for _, m := range mx { p.linkDomain(r, "USES_MX", host, m) }
+	if p.opts.ZoneRecords {
+		for _, ip := range ips { p.collectPTR(ctx, r, ip) }
 	}
+	p.attribute(r, rec)
 
-	// Policy
-	if robots.ShouldSkipByTLD(host, p.excluded) {
-		p.flush(nodesD, nodesIP, nodesC, edges)
+	// Nothing to fetch from a name that does not exist, but a CNAME into
+	// the void may still be claimable
+	if rec.HostStatus() == dns.StatusNXDomain {
+		p.checkTakeover(ctx, r, rec.CNAMEs)
+		p.flush(r)
 		return
 	}
-	rd, _ := p.rob.Get(ctx, host)
-	if !robots.Allowed(rd, p.ua, "/") {
-		metrics.RobotsBlocks.Inc()
-		p.flush(nodesD, nodesIP, nodesC, edges)
+
+	// Policy. A host we may not fetch still gets the DNS half of the
+	// takeover check.
+	blocked := robots.ShouldSkipByTLD(host, p.excluded)
+	var rd *robotstxt.RobotsData
+	if !blocked {
+		rd, _ = p.rob.Get(ctx, host)
+		if !robots.Allowed(rd, p.ua, "/") { metrics.RobotsBlocks.Inc(); blocked = true }
+	}
+	if blocked {
+		p.checkTakeover(ctx, r, rec.CNAMEs)
+		p.flush(r)
 		return
 	}
 
-	// Per-host rate limit
-	p.ratelim.Wait(host)
+	// Try each scheme/port candidate until one answers
+	var served *url.URL
+	for _, c := range p.opts.RootCandidates {
+		root, ok := candidateURL(host, c)
+		if !ok { continue }
+		if p.crawlPages(ctx, host, root, rd, r) {
+			metrics.RootFetches.WithLabelValues(c, "ok").Inc()
+			served = root
+			break
+		}
+		metrics.RootFetches.WithLabelValues(c, "error").Inc()
+	}
+	// fingerprinted against the root page already fetched above
+	p.checkTakeover(ctx, r, rec.CNAMEs)
 
-	// GET root HTML with separate timeout context
-	root := &url.URL{Scheme: "https", Host: host, Path: "/"}
+	var hostSPKI string
+	if cert, obs, err := tlsinfo.Observe(ctx, host, tlsinfo.Options{Dial: p.pinnedDial(host, ips), Roots: p.opts.RootCAs}); err == nil {
+		if obs.Validation != "" { metrics.CertValidations.WithLabelValues(obs.Validation).Inc() }
+		if p.opts.TLSFingerprint { obs.Fingerprint = tlsinfo.Fingerprint(ctx, host, tlsinfo.Options{Dial: p.pinnedDial(host, ips)}) }
+		r.nodesT = append(r.nodesT, *obs)
+		if cert != nil {
+			if !p.dedup.Seen("cert|"+cert.SPKI) { r.nodesC = append(r.nodesC, *cert) }
+			p.edge(r, "USES_CERT", host, cert.SPKI)
+			hostSPKI = cert.SPKI
+		}
+	}
+
+	if p.opts.MXCerts { p.collectMXCerts(ctx, r, mx) }
+	if p.opts.DefaultCerts { p.collectDefaultCerts(ctx, r, hostSPKI) }
+
+	if p.opts.FetchEachIP {
+		for _, ip := range ips {
+			if served != nil {
+				p.ratelim.Wait(host)
+				p.fetchPage(egress.WithIPs(ctx, host, []string{ip}), p.hcPerIP, served, r)
+			}
+			if cert, err := tlsinfo.FetchCert(ctx, host, tlsinfo.Options{Dial: p.pinnedDial(host, []string{ip})}); err == nil && cert != nil {
+				if !p.dedup.Seen("cert|"+cert.SPKI) { r.nodesC = append(r.nodesC, *cert) }
+				p.edge(r, "PRESENTS_CERT", ip, cert.SPKI)
+			}
+		}
+	}
+
+	p.flush(r)
+}
+
+// crawlPages fetches up to MaxPages pages breadth-first starting at root,
+// following only same-apex navigation links that robots.txt allows. It
+// reports whether the root itself produced an HTTP response and keeps its
+// body in r.root.
+func (p *Probe) crawlPages(ctx context.Context, host string, root *url.URL, rd *robotstxt.RobotsData, r *results) bool {
+	ctx = egress.WithIPs(ctx, host, r.ips)
+	apex := extract.Apex(host)
+	queue := []*url.URL{root}
+	seen := map[string]bool{root.String(): true}
+	for fetched := 0; len(queue) > 0 && fetched < p.opts.MaxPages; {
+		u := queue[0]
+		queue = queue[1:]
+		if fetched > 0 {
+			prd := rd
+			if u.Host != root.Host { prd, _ = p.rob.Get(ctx, u.Host) }
+			if !robots.Allowed(prd, p.ua, u.EscapedPath()) { metrics.RobotsBlocks.Inc(); continue }
+		}
+		if h := u.Hostname(); h != host && !p.dedup.Seen("domain|"+h) {
+			r.nodesD = append(r.nodesD, emit.NodeDomain{Host: h, Apex: apex, FirstSeen: r.now, LastSeen: r.now})
+		}
+		p.ratelim.Wait(u.Hostname())
+		links, body, ok := p.fetchPage(ctx, p.hc, u, r)
+		if !ok && fetched == 0 { return false }
+		if fetched == 0 { r.root = body }
+		fetched++
+		for _, l := range links {
+			if l.Element != "a" && l.Element != "area" { continue }
+			n, err := url.Parse(l.URL)
+			if err != nil || (n.Scheme != "http" && n.Scheme != "https") || extract.Apex(n.Hostname()) != apex { continue }
+			n.Fragment = ""
+			if seen[n.String()] { continue }
+			seen[n.String()] = true
+			queue = append(queue, n)
+		}
+	}
+	return true
+}
+
+// fetchPage GETs u, records the response observation and the relationships
+// declared by its headers and HTML, and returns the links found on the page
+// and the first 512 KiB of its body, whatever the status. ok is false when
+// no response was received; the failure is still recorded.
+// When the connection was pinned to a resolved IP a SERVES edge records it.
+func (p *Probe) fetchPage(ctx context.Context, hc *httpclient.ResilientClient, u *url.URL, r *results) (links []extract.Link, body []byte, ok bool) {
+	host := u.Hostname()
+	var servedBy string
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{GotConn: func(ci httptrace.GotConnInfo) { servedBy = egress.PinnedIP(ci.Conn) }})
 	httpCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
-	req, _ := http.NewRequestWithContext(httpCtx, "GET", root.String(), nil)
+	req, _ := http.NewRequestWithContext(httpCtx, "GET", u.String(), nil)
 	req.Header.Set("User-Agent", p.ua)
-	resp, err := p.hc.Do(req)
-	if err == nil {
-		ct := strings.ToLower(resp.Header.Get("Content-Type"))
-		if strings.Contains(ct, "text/html") && resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			body := io.LimitReader(resp.Body, 512*1024)
-			links, _ := extract.ParseLinks(root, body)
-			outs := extract.ExternalDomains(host, links)
-			for _, h := range outs {
-				if !p.dedup.Seen("domain|"+h) { nodesD = append(nodesD, emit.NodeDomain{Host: h, Apex: extract.Apex(h), FirstSeen: now, LastSeen: now}) }
-				k := "edge|"+host+"|LINKS_TO|"+h
-				if !p.dedup.Seen(k) { edges = append(edges, emit.Edge{Type: "LINKS_TO", Source: host, Target: h, ObservedAt: now, ProbeID: p.probeID, RunID: p.runID}); metrics.EdgesTotal.WithLabelValues("LINKS_TO").Inc() }
-			}
+	start := time.Now()
+	// 5xx responses come back alongside a breaker error; observe them too
+	resp, err := hc.Do(req)
+	if resp == nil {
+		r.nodesH = append(r.nodesH, emit.NodeHTTP{Host: host, URL: u.String(), Error: err.Error(), ObservedAt: time.Now().UTC()})
+		return nil, nil, false
+	}
+	defer resp.Body.Close()
+	elapsed := time.Since(start)
+	base := resp.Request.URL
+	cr := &httpinfo.CountingReader{R: resp.Body}
+	ct := strings.ToLower(resp.Header.Get("Content-Type"))
+	body, _ = io.ReadAll(io.LimitReader(cr, 512*1024))
+	if strings.Contains(ct, "text/html") && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		links, _ = extract.ParseLinks(base, bytes.NewReader(body))
+		byType := extract.ByEdgeType(links)
+		for _, typ := range extract.EdgeTypes(byType) {
+			for _, h := range extract.ExternalDomains(host, byType[typ]) { p.linkDomain(r, typ, host, h) }
 		}
-		io.Copy(io.Discard, resp.Body); resp.Body.Close()
 	}
+	declared := extract.HeaderLinks(base, resp.Header)
+	for _, typ := range extract.EdgeTypes(declared) {
+		for _, h := range extract.ExternalDomains(host, declared[typ]) { p.linkDomain(r, typ, host, h) }
+	}
+	io.Copy(io.Discard, cr)
+	obs := httpinfo.Observe(host, u.String(), resp, elapsed, cr.N)
+	obs.IP = servedBy
+	r.nodesH = append(r.nodesH, obs)
+	if servedBy != "" { p.edge(r, "SERVES", servedBy, host) }
+	return links, body, true
+}
 
-	if cert, err := tlsinfo.FetchCert(host); err == nil && cert != nil {
-		if !p.dedup.Seen("cert|"+cert.SPKI) { nodesC = append(nodesC, *cert) }
-		k := "edge|"+host+"|USES_CERT|"+cert.SPKI
-		if !p.dedup.Seen(k) { edges = append(edges, emit.Edge{Type: "USES_CERT", Source: host, Target: cert.SPKI, ObservedAt: now, ProbeID: p.probeID, RunID: p.runID}); metrics.EdgesTotal.WithLabelValues("USES_CERT").Inc() }
+// candidateURL builds the root URL for a "scheme:port" candidate such as
+// "https:443" or "http:8080", omitting the port when it is the default.
+func candidateURL(host, c string) (*url.URL, bool) {
+	scheme, port, ok := strings.Cut(strings.ToLower(strings.TrimSpace(c)), ":")
+	if !ok || (scheme != "http" && scheme != "https") { return nil, false }
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 { return nil, false }
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		return &url.URL{Scheme: scheme, Host: host, Path: "/"}, true
 	}
+	return &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, port), Path: "/"}, true
+}
+
+// results accumulates the nodes and edges found while crawling one host.
+type results struct {
+	now     time.Time
+	host    string
+	ips     []string
+	root    []byte // root page body, for takeover fingerprints
+	nodesD  []emit.NodeDomain
+	nodesIP []emit.NodeIP
+	nodesC  []emit.NodeCert
+	nodesH  []emit.NodeHTTP
+	nodesT  []emit.NodeTLS
+	nodesA  []emit.NodeASN
+	nodesP  []emit.NodePrefix
+	nodesV  []emit.NodeProvider
+	nodesG  []emit.NodeRegistrar
+	nodesR  []emit.NodeRegistration
+	edges   []emit.Edge
+	finds   []emit.Finding
+}
+
+// runKey scopes a dedup key to this run, for work done once per run: the
+// dedup store may be shared and outlive the run, and a later run must
+// still do the work.
+func (p *Probe) runKey(key string) string { return "run|" + p.runID + "|" + key }
+
+// linkDomain records h as a domain node and a typ edge from host to it.
+func (p *Probe) linkDomain(r *results, typ, host, h string) {
+	if !p.dedup.Seen("domain|"+h) { r.nodesD = append(r.nodesD, emit.NodeDomain{Host: h, Apex: extract.Apex(h), FirstSeen: r.now, LastSeen: r.now}) }
+	p.edge(r, typ, host, h)
+}
+
+// edge records a typ edge from src to dst unless it was already emitted.
+func (p *Probe) edge(r *results, typ, src, dst string) {
+	p.edgeAttrs(r, typ, src, dst, nil)
+}
+
+// edgeAttrs is edge with attributes qualifying the relationship.
+func (p *Probe) edgeAttrs(r *results, typ, src, dst string, attrs map[string]string) {
+	k := "edge|"+src+"|"+typ+"|"+dst
+	if !p.dedup.Seen(k) { r.edges = append(r.edges, emit.Edge{Type: typ, Source: src, Target: dst, Attrs: attrs, ObservedAt: r.now, ProbeID: p.probeID, RunID: p.runID}); metrics.EdgesTotal.WithLabelValues(typ).Inc() }
+}
 
-	p.flush(nodesD, nodesIP, nodesC, edges)
+// resolvesTo records ip as an address of host. Addresses that merely match
+// the zone's wildcard are marked, or dropped with SuppressWildcard.
+func (p *Probe) resolvesTo(r *results, host, ip string, version int, wildcard bool) {
+	if wildcard && p.opts.SuppressWildcard { return }
+	if !p.dedup.Seen("nodeip|"+ip) { r.nodesIP = append(r.nodesIP, p.ipNode(r, ip, version)) }
+	var attrs map[string]string
+	if wildcard { attrs = map[string]string{"wildcard": "true"} }
+	p.edgeAttrs(r, "RESOLVES_TO", host, ip, attrs)
+}
+
+// ipNode returns the node for ip, attributed to its provider with a
+// HOSTED_ON edge and enriched from the local MMDB files with its country,
+// an IN_PREFIX edge to its announced prefix and, once per prefix, an
+// ANNOUNCED_BY edge to the origin AS.
+func (p *Probe) ipNode(r *results, ip string, version int) emit.NodeIP {
+	n := emit.NodeIP{IP: ip, Version: version, FirstSeen: r.now, LastSeen: r.now}
+	if p.opts.Providers != nil {
+		if a, ok := p.opts.Providers.IP(ip); ok {
+			n.Provider, n.Service = a.Provider, a.Service
+			p.hostedOn(r, ip, a, "range")
+		}
+	}
+	if p.opts.MMDB == nil { return n }
+	info := p.opts.MMDB.Lookup(ip)
+	n.Country = info.Country
+	if info.Prefix == "" { return n }
+	if !p.dedup.Seen("prefix|"+info.Prefix) {
+		r.nodesP = append(r.nodesP, emit.NodePrefix{Prefix: info.Prefix, ASN: info.ASN, FirstSeen: r.now, LastSeen: r.now})
+		if info.ASN != 0 {
+			asn := enrich.ASNKey(info.ASN)
+			if !p.dedup.Seen("asn|"+asn) { r.nodesA = append(r.nodesA, emit.NodeASN{Key: asn, ASN: info.ASN, Org: info.Org, FirstSeen: r.now, LastSeen: r.now}) }
+			p.edge(r, "ANNOUNCED_BY", info.Prefix, asn)
+		}
+	}
+	p.edge(r, "IN_PREFIX", ip, info.Prefix)
+	return n
+}
+
+// attribute tags the crawled host with the provider serving it, judged by
+// its CNAME targets and then its addresses, and links it with HOSTED_ON.
+// Who hosts its DNS is recorded per apex by dnsHostedOn.
+func (p *Probe) attribute(r *results, rec dns.Records) {
+	c := p.opts.Providers
+	if c == nil { return }
+	host := &r.nodesD[0]
+	for _, t := range rec.CNAMEs {
+		if a, ok := c.Host(t); ok { host.Provider, host.Service = a.Provider, a.Service; p.hostedOn(r, host.Host, a, "cname"); break }
+	}
+	if host.Provider == "" {
+		for _, ip := range rec.IPs() {
+			if a, ok := c.IP(ip); ok { host.Provider, host.Service = a.Provider, a.Service; p.hostedOn(r, host.Host, a, "ip"); break }
+		}
+	}
+}
+
+// hostedOn links src to the provider in a, recording how it was matched.
+// The first match per provider wins.
+func (p *Probe) hostedOn(r *results, src string, a enrich.Attribution, via string) {
+	p.providerNode(r, a.Provider)
+	attrs := map[string]string{"via": via}
+	if a.Service != "" { attrs["service"] = a.Service }
+	p.edgeAttrs(r, "HOSTED_ON", src, a.Provider, attrs)
+}
+
+// dnsHostedOn links apex with DNS_HOSTED_ON to the provider of each of its
+// name servers, naming the first server matched. It is kept apart from
+// HOSTED_ON, which says who serves the content.
+func (p *Probe) dnsHostedOn(r *results, apex string, servers []string) {
+	c := p.opts.Providers
+	if c == nil { return }
+	for _, ns := range servers {
+		a, ok := c.Host(ns)
+		if !ok { continue }
+		p.providerNode(r, a.Provider)
+		attrs := map[string]string{"ns": ns}
+		if a.Service != "" { attrs["service"] = a.Service }
+		p.edgeAttrs(r, "DNS_HOSTED_ON", apex, a.Provider, attrs)
+	}
+}
+
+// providerNode records the provider once per run.
+func (p *Probe) providerNode(r *results, name string) {
+	if !p.dedup.Seen("provider|"+name) { r.nodesV = append(r.nodesV, emit.NodeProvider{Name: name, FirstSeen: r.now, LastSeen: r.now}) }
+}
+
+// enumerate brute-forces the subdomains of host's apex once per run,
+// records a SUBDOMAIN_OF edge for each and returns those other than host
+// for crawling.
+func (p *Probe) enumerate(ctx context.Context, host string) []string {
+	if p.enum == nil { return nil }
+	apex := extract.Apex(host)
+	key := p.runKey("enum|" + apex)
+	if robots.ShouldSkipByTLD(apex, p.excluded) || p.dedup.Has(key) { return nil }
+	// until the wildcard probe gets an answer the zone is left for the next
+	// host under it rather than marked enumerated
+	if !p.resolver.Wildcard(ctx, apex).Known || p.dedup.Seen(key) { return nil }
+	r := &results{now: time.Now().UTC(), host: apex}
+	var subs []string
+	for _, s := range p.enum.Enumerate(ctx, apex) {
+		p.linkDomain(r, "SUBDOMAIN_OF", s, apex)
+		if s != host { subs = append(subs, s) }
+	}
+	p.flush(r)
+	return subs
+}
+
+// checkTakeover runs the takeover detector, when enabled, over host's
+// CNAME chain, fingerprinting the root page crawlPages fetched, if any.
+func (p *Probe) checkTakeover(ctx context.Context, r *results, chain []string) {
+	if p.takeover == nil || len(chain) == 0 { return }
+	if c := p.takeover.Check(ctx, chain, string(r.root)); c != nil { p.finding(r, c.Kind, r.host, c.Target, c.Service, c.Evidence) }
+}
+
+// finding records a finding about host unless it was already emitted.
+func (p *Probe) finding(r *results, kind, host, target, service string, evidence []string) {
+	if p.dedup.Seen("finding|"+kind+"|"+host+"|"+target) { return }
+	r.finds = append(r.finds, emit.Finding{Kind: kind, Host: host, Target: target, Service: service, Evidence: evidence, ObservedAt: r.now, ProbeID: p.probeID, RunID: p.runID})
+	metrics.FindingsTotal.WithLabelValues(kind).Inc()
+}
+
+// collectZone records the zone-level DNS of apex once per run: whether it
+// is a wildcard zone and, with ZoneRecords on, the SOA primary, the CAs its
+// CAA records authorize, the targets of common SRV services and whether
+// the zone is DNSSEC-signed; then its name servers' AXFR and delegation
+// checks and, with RDAP on, its registration. Under Run the registration
+// is looked up by separate workers and emitted in its own batch.
+func (p *Probe) collectZone(ctx context.Context, r *results, apex string) {
+	if p.dedup.Seen(p.runKey("zone|"+apex)) { return }
+	wc := p.resolver.Wildcard(ctx, apex)
+	node := emit.NodeDomain{Host: apex, Apex: apex, Wildcard: wc.Enabled, FirstSeen: r.now, LastSeen: r.now}
+	if p.opts.ZoneRecords {
+		z := p.resolver.LookupZone(ctx, apex)
+		node.DNSSEC = z.DNSSEC
+		if z.SOA != nil && z.SOA.MName != "" { p.linkDomain(r, "SOA_PRIMARY", apex, z.SOA.MName) }
+		for _, c := range z.CAA {
+			if is := c.Issuer(); is != "" { p.linkDomain(r, "CAA_AUTHORIZES", apex, is) }
+		}
+		for _, s := range z.SRV {
+			// a target of "." means the service is explicitly unavailable
+			if s.Target != "" { p.linkDomain(r, "HAS_SRV", apex, s.Target) }
+		}
+	}
+	p.apexNode(r, node)
+	// the apex NS set is only needed by these
+	if p.opts.AXFR || p.opts.Delegation || p.opts.Providers != nil {
+		servers := p.resolver.LookupNS(ctx, apex)
+		p.dnsHostedOn(r, apex, servers)
+		if p.opts.AXFR || p.opts.Delegation { p.apexNS(ctx, r, apex, servers) }
+	}
+	if p.rdap == nil { return }
+	if p.regs == nil { p.register(ctx, r, apex); return }
+	select {
+	case p.regs <- apex:
+	case <-ctx.Done():
+	}
+}
+
+// apexNS runs the delegation check and zone transfers over the NS set of
+// apex and records it as USES_NS edges carrying their results.
+func (p *Probe) apexNS(ctx context.Context, r *results, apex string, servers []string) {
+	nsAttrs := make(map[string]map[string]string)
+	if p.opts.Delegation { servers = p.checkDelegation(ctx, r, apex, servers, nsAttrs) }
+	for _, ns := range servers {
+		attrs := nsAttrs[ns]
+		if p.opts.AXFR {
+			if attrs == nil { attrs = make(map[string]string) }
+			attrs["axfr"] = p.transferZone(ctx, r, apex, ns)
+		}
+		if !p.dedup.Seen("domain|"+ns) { r.nodesD = append(r.nodesD, emit.NodeDomain{Host: ns, Apex: extract.Apex(ns), FirstSeen: r.now, LastSeen: r.now}) }
+		p.edgeAttrs(r, "USES_NS", apex, ns, attrs)
+	}
+}
+
+// apexNode records the zone attributes of an apex. They are merged into
+// the batch's node for the apex when it has one, typically the crawled host
+// itself, so a batch never carries two nodes for one host. An apex already
+// emitted bare as a link or record target is emitted again when it has
+// attributes to add.
+func (p *Probe) apexNode(r *results, n emit.NodeDomain) {
+	for i := range r.nodesD {
+		if r.nodesD[i].Host == n.Host { r.nodesD[i].DNSSEC, r.nodesD[i].Wildcard = n.DNSSEC, n.Wildcard; return }
+	}
+	if p.dedup.Seen("domain|"+n.Host) && n.DNSSEC == "" && !n.Wildcard { return }
+	r.nodesD = append(r.nodesD, n)
+}
+
+// register records the registry's view of apex and links it to its
+// registrar with REGISTERED_WITH. Lookup failures only cost the record.
+func (p *Probe) register(ctx context.Context, r *results, apex string) {
+	if p.rdap == nil { return }
+	reg, err := p.rdap.Lookup(ctx, apex)
+	if err != nil { p.log.Debugw("rdap lookup failed", "apex", apex, "err", err); return }
+	date := func(t time.Time) *time.Time {
+		if t.IsZero() { return nil }
+		return &t
+	}
+	r.nodesR = append(r.nodesR, emit.NodeRegistration{Domain: apex, Registrar: reg.Registrar, RegistrantOrg: reg.RegistrantOrg, Created: date(reg.Created), Updated: date(reg.Updated), Expires: date(reg.Expires), Nameservers: reg.Nameservers, Status: reg.Status, ObservedAt: r.now})
+	if reg.Registrar == "" { return }
+	if !p.dedup.Seen("registrar|"+reg.Registrar) { r.nodesG = append(r.nodesG, emit.NodeRegistrar{Name: reg.Registrar, IANAID: reg.RegistrarID, FirstSeen: r.now, LastSeen: r.now}) }
+	p.edge(r, "REGISTERED_WITH", apex, reg.Registrar)
+}
+
+// checkDelegation queries the parent and each authoritative server of apex
+// directly, records every server's health and issues in attrs, and returns
+// servers extended with any the parent delegates to but the zone omits.
+func (p *Probe) checkDelegation(ctx context.Context, r *results, apex string, servers []string, attrs map[string]map[string]string) []string {
+	d := p.resolver.CheckDelegation(ctx, apex)
+	all := append([]string(nil), servers...)
+	for _, s := range d.Servers {
+		if !slices.Contains(all, s.NS) { all = append(all, s.NS) }
+		attrs[s.NS] = map[string]string{"delegation": s.Status}
+	}
+	for _, is := range d.Issues() {
+		a := attrs[is.NS]
+		if a["delegation_issues"] != "" { a["delegation_issues"] += "," }
+		a["delegation_issues"] += is.Kind
+		p.finding(r, is.Kind, apex, is.NS, "", is.Evidence)
+	}
+	return all
+}
+
+// transferZone attempts AXFR of apex from each address of ns until one
+// answers and returns the outcome. An allowed transfer is a finding, and
+// the zone's names are imported as domain nodes with SUBDOMAIN_OF edges
+// and their A, AAAA, CNAME, MX and NS records as the usual edges.
+func (p *Probe) transferZone(ctx context.Context, r *results, apex, ns string) string {
+	addrs, _ := p.resolver.LookupAddrs(ctx, ns, false)
+	v6, _ := p.resolver.LookupAddrs(ctx, ns, true)
+	addrs = append(addrs, v6...)
+	if len(addrs) == 0 { return dns.AXFRFailed }
+	var t dns.Transfer
+	for _, ip := range addrs {
+		if t = p.resolver.AXFR(ctx, apex, net.JoinHostPort(ip, "53")); t.Status != dns.AXFRFailed { break }
+	}
+	metrics.AXFRAttempts.WithLabelValues(t.Status).Inc()
+	if t.Status != dns.AXFRAllowed { return t.Status }
+	names, entries := t.Entries()
+	p.finding(r, "AXFR_ALLOWED", apex, ns, "", []string{fmt.Sprintf("records:%d", len(t.Records)), fmt.Sprintf("names:%d", len(names))})
+	for _, n := range names {
+		if n == apex { continue }
+		if !p.dedup.Seen("domain|"+n) { r.nodesD = append(r.nodesD, emit.NodeDomain{Host: n, Apex: apex, FirstSeen: r.now, LastSeen: r.now}) }
+		p.linkDomain(r, "SUBDOMAIN_OF", n, apex)
+	}
+	for _, e := range entries {
+		switch e.Type {
+		case "A":
+			p.resolvesTo(r, e.Name, e.Target, 4, false)
+		case "AAAA":
+			p.resolvesTo(r, e.Name, e.Target, 6, false)
+		case "CNAME":
+			p.linkDomain(r, "ALIAS_OF", e.Name, e.Target)
+		case "MX":
+			p.linkDomain(r, "USES_MX", e.Name, e.Target)
+		case "NS":
+			if e.Name != apex { p.linkDomain(r, "USES_NS", e.Name, e.Target) }
+		}
+	}
+	return t.Status
+}
+
+// collectPTR records the names ip reverse-resolves to, once per run.
+func (p *Probe) collectPTR(ctx context.Context, r *results, ip string) {
+	if p.dedup.Seen(p.runKey("ptr|"+ip)) { return }
+	names, err := p.resolver.LookupPTR(ctx, ip)
+	if err != nil { return }
+	for _, n := range names { p.linkDomain(r, "REVERSE_OF", ip, n) }
+}
+
+// mailServices are the STARTTLS services collectMXCerts tries on each mail
+// host. SMTP stops at the first port that hands over a certificate.
+var mailServices = []struct{ proto, port string }{
+	{tlsinfo.ProtoSMTP, "25"}, {tlsinfo.ProtoSMTP, "587"}, {tlsinfo.ProtoIMAP, "143"}, {tlsinfo.ProtoPOP3, "110"},
+}
+
+// collectMXCerts fetches each mail server's STARTTLS certificate for every
+// mail protocol once per run. A protocol is only marked done after a
+// successful handshake, so a transient failure is retried by the next
+// domain sharing the server.
+func (p *Probe) collectMXCerts(ctx context.Context, r *results, mx []string) {
+	for _, m := range mx {
+		for _, svc := range mailServices {
+			if ctx.Err() != nil { return }
+			key := p.runKey("mxcert|" + svc.proto + "|" + m)
+			if p.dedup.Has(key) { continue }
+			cert, err := tlsinfo.FetchCert(ctx, m, tlsinfo.Options{Port: svc.port, StartTLS: svc.proto, Timeout: 15 * time.Second, Dial: p.dialer.DialContext})
+			if err != nil || cert == nil { continue }
+			p.dedup.Seen(key)
+			if !p.dedup.Seen("cert|"+cert.SPKI) { r.nodesC = append(r.nodesC, *cert) }
+			p.edge(r, "USES_CERT", m, cert.SPKI)
+		}
+	}
+}
+
+// collectDefaultCerts handshakes with each of the host's IPs without SNI
+// and emits a DEFAULT_CERT edge from the IP when the certificate served
+// differs from hostSPKI, the one presented for the host name itself.
+func (p *Probe) collectDefaultCerts(ctx context.Context, r *results, hostSPKI string) {
+	for _, ip := range r.ips {
+		if ctx.Err() != nil { return }
+		if p.dedup.Seen(p.runKey("defaultcert|"+ip)) { continue }
+		cert, err := tlsinfo.FetchCert(ctx, r.host, tlsinfo.Options{NoSNI: true, Dial: p.pinnedDial(r.host, []string{ip})})
+		if err != nil || cert == nil || cert.SPKI == hostSPKI { continue }
+		if !p.dedup.Seen("cert|"+cert.SPKI) { r.nodesC = append(r.nodesC, *cert) }
+		p.edge(r, "DEFAULT_CERT", ip, cert.SPKI)
+	}
+}
+
+// pinnedDial returns a dial function that connects to host via ips.
+func (p *Probe) pinnedDial(host string, ips []string) egress.DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return p.dialer.DialContext(egress.WithIPs(ctx, host, ips), network, addr)
+	}
 }
 
-func (p *Probe) flush(nd []emit.NodeDomain, ni []emit.NodeIP, nc []emit.NodeCert, e []emit.Edge) {
-	if len(nd)+len(ni)+len(nc)+len(e) == 0 { return }
-	p.out <- emit.Batch{ProbeID: p.probeID, RunID: p.runID, NodesD: nd, NodesIP: ni, NodesC: nc, Edges: e}
+func (p *Probe) flush(r *results) {
+	b := emit.Batch{ProbeID: p.probeID, RunID: p.runID, NodesD: r.nodesD, NodesIP: r.nodesIP, NodesC: r.nodesC, NodesHTTP: r.nodesH, NodesTLS: r.nodesT, NodesASN: r.nodesA, NodesPfx: r.nodesP, NodesProv: r.nodesV, NodesRgr: r.nodesG, NodesReg: r.nodesR, Edges: r.edges, Findings: r.finds}
+	if b.NodeCount()+len(b.Edges)+len(b.Findings) == 0 { return }
+	p.out <- b
 }
@@ -0,0 +1,590 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gustycube/spyder/internal/dedup"
+	"github.com/gustycube/spyder/internal/dns"
+	"github.com/gustycube/spyder/internal/dns/dnstest"
+	"github.com/gustycube/spyder/internal/emit"
+	"github.com/gustycube/spyder/internal/enrich"
+	"github.com/gustycube/spyder/internal/enum"
+	"github.com/gustycube/spyder/internal/logging"
+	"github.com/gustycube/spyder/internal/rate"
+	"github.com/gustycube/spyder/internal/robots"
+	"github.com/gustycube/spyder/internal/takeover"
+	"github.com/gustycube/spyder/internal/tlsinfo"
+	"github.com/temoto/robotstxt"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newTestProbe(opts *Options) *Probe {
+	p := New("TestBot/1.0", "test", "run", nil, dedup.NewMemory(), make(chan emit.Batch, 16), opts, logging.New())
+	p.ratelim = rate.New(1000, 100)
+	return p
+}
+
+func TestCrawlPages_Budget(t *testing.T) {
+	var mu sync.Mutex
+	var hits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits = append(hits, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/about">a</a><a href="/about#team">a</a><a href="/private/x">p</a><a href="/privacy">p</a><a href="/contact">c</a><script src="https://cdn.root.net/a.js"></script>`))
+		case "/about":
+			w.Write([]byte(`<a href="/">home</a><iframe src="https://widget.other.org/"></iframe>`))
+		default:
+			w.Write([]byte(`<a href="https://partner.io/">partner</a>`))
+		}
+	}))
+	defer server.Close()
+
+	p := newTestProbe(&Options{MaxPages: 3})
+	root, _ := url.Parse(server.URL + "/")
+	rd, _ := robotstxt.FromBytes([]byte("User-agent: *\nDisallow: /private/\n"))
+	r := &results{now: time.Now()}
+
+	p.crawlPages(context.Background(), root.Hostname(), root, rd, r)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"/", "/about", "/privacy"}
+	if len(hits) != len(want) {
+		t.Fatalf("expected pages %v, got %v", want, hits)
+	}
+	for i := range want {
+		if hits[i] != want[i] {
+			t.Errorf("expected pages %v, got %v", want, hits)
+			break
+		}
+	}
+
+	edges := make(map[string]string)
+	for _, e := range r.edges {
+		edges[e.Target] = e.Type
+	}
+	if edges["cdn.root.net"] != "LOADS_SCRIPT" {
+		t.Errorf("expected LOADS_SCRIPT edge to cdn.root.net, got %v", edges)
+	}
+	if edges["widget.other.org"] != "EMBEDS_IFRAME" {
+		t.Errorf("expected EMBEDS_IFRAME edge from /about, got %v", edges)
+	}
+	if edges["partner.io"] != "LINKS_TO" {
+		t.Errorf("expected LINKS_TO edge from /privacy, got %v", edges)
+	}
+	if len(r.nodesH) != 3 {
+		t.Errorf("expected 3 HTTP observations, got %d", len(r.nodesH))
+	}
+}
+
+func TestCrawlPages_RootFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	addr := server.URL
+	server.Close()
+
+	p := newTestProbe(nil)
+	root, _ := url.Parse(addr + "/")
+	rd, _ := robotstxt.FromBytes(nil)
+	r := &results{now: time.Now()}
+
+	if p.crawlPages(context.Background(), root.Hostname(), root, rd, r) {
+		t.Error("expected crawlPages to report an unreachable root")
+	}
+	if len(r.nodesH) != 1 || r.nodesH[0].Error == "" {
+		t.Errorf("expected the failed attempt to be recorded, got %+v", r.nodesH)
+	}
+}
+
+func TestCheckTakeover_RootBody(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("There isn't a GitHub Pages site here."))
+	}))
+	defer server.Close()
+	resolver := dns.NewResolver([]string{dnstest.Dead(t)})
+	resolver.Timeout = 50 * time.Millisecond
+
+	p := newTestProbe(&Options{MaxPages: 1})
+	p.takeover = takeover.New(nil, resolver)
+	root, _ := url.Parse(server.URL + "/")
+	rd, _ := robotstxt.FromBytes(nil)
+	r := &results{now: time.Now(), host: "docs.example.com"}
+
+	if !p.crawlPages(context.Background(), root.Hostname(), root, rd, r) {
+		t.Fatal("expected the root to answer")
+	}
+	p.checkTakeover(context.Background(), r, []string{"example.github.io"})
+	if len(r.finds) != 1 || r.finds[0].Kind != takeover.KindCandidate {
+		t.Errorf("expected a candidate from the crawled root page, got %+v", r.finds)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("expected the detector to reuse the crawled page, got %d requests", n)
+	}
+}
+
+func TestCandidateURL(t *testing.T) {
+	tests := []struct {
+		candidate string
+		want      string
+		ok        bool
+	}{
+		{"https:443", "https://example.com/", true},
+		{"http:80", "http://example.com/", true},
+		{"https:8443", "https://example.com:8443/", true},
+		{"HTTP:8080", "http://example.com:8080/", true},
+		{"ftp:21", "", false},
+		{"https", "", false},
+		{"http:99999", "", false},
+	}
+	for _, tt := range tests {
+		u, ok := candidateURL("example.com", tt.candidate)
+		if ok != tt.ok {
+			t.Errorf("candidateURL(%q) ok = %v, want %v", tt.candidate, ok, tt.ok)
+			continue
+		}
+		if ok && u.String() != tt.want {
+			t.Errorf("candidateURL(%q) = %s, want %s", tt.candidate, u, tt.want)
+		}
+	}
+}
+
+func TestResolvesTo_Wildcard(t *testing.T) {
+	p := newTestProbe(nil)
+	r := &results{now: time.Now()}
+	p.resolvesTo(r, "a.wild.test", "198.51.100.7", 4, true)
+	p.resolvesTo(r, "a.wild.test", "192.0.2.1", 4, false)
+	if len(r.edges) != 2 || r.edges[0].Attrs["wildcard"] != "true" || r.edges[1].Attrs != nil {
+		t.Errorf("expected only the wildcard address marked, got %+v", r.edges)
+	}
+
+	p = newTestProbe(&Options{SuppressWildcard: true})
+	r = &results{now: time.Now()}
+	p.resolvesTo(r, "a.wild.test", "198.51.100.7", 4, true)
+	p.resolvesTo(r, "a.wild.test", "192.0.2.1", 4, false)
+	if len(r.edges) != 1 || r.edges[0].Target != "192.0.2.1" || len(r.nodesIP) != 1 {
+		t.Errorf("expected the wildcard address suppressed, got %+v", r.edges)
+	}
+}
+
+func TestApexNode_Merge(t *testing.T) {
+	p := newTestProbe(nil)
+	r := &results{now: time.Now()}
+	p.dedup.Seen("domain|example.com")
+	r.nodesD = append(r.nodesD, emit.NodeDomain{Host: "example.com", Apex: "example.com", Status: "resolves"})
+	p.apexNode(r, emit.NodeDomain{Host: "example.com", Apex: "example.com", DNSSEC: "signed", Wildcard: true})
+	if len(r.nodesD) != 1 || r.nodesD[0].DNSSEC != "signed" || !r.nodesD[0].Wildcard || r.nodesD[0].Status != "resolves" {
+		t.Errorf("expected one merged apex node, got %+v", r.nodesD)
+	}
+
+	r = &results{now: time.Now()}
+	p.apexNode(r, emit.NodeDomain{Host: "example.com", Apex: "example.com"})
+	if len(r.nodesD) != 0 {
+		t.Errorf("expected a seen apex without attributes to be skipped, got %+v", r.nodesD)
+	}
+	p.apexNode(r, emit.NodeDomain{Host: "example.com", Apex: "example.com", DNSSEC: "unsigned"})
+	if len(r.nodesD) != 1 {
+		t.Errorf("expected a seen apex with attributes to be emitted, got %+v", r.nodesD)
+	}
+}
+
+func TestCrawlPages_PinnedIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html></html>`))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	p := newTestProbe(nil)
+	root, _ := url.Parse("http://pinned.invalid:" + port + "/")
+	rd, _ := robotstxt.FromBytes(nil)
+	r := &results{now: time.Now(), host: "pinned.invalid", ips: []string{"127.0.0.1"}}
+
+	if !p.crawlPages(context.Background(), "pinned.invalid", root, rd, r) {
+		t.Fatalf("expected root to be served via the pinned IP, got %+v", r.nodesH)
+	}
+	if len(r.nodesH) != 1 || r.nodesH[0].IP != "127.0.0.1" {
+		t.Errorf("expected observation served by 127.0.0.1, got %+v", r.nodesH)
+	}
+	if len(r.edges) != 1 || r.edges[0].Type != "SERVES" || r.edges[0].Source != "127.0.0.1" || r.edges[0].Target != "pinned.invalid" {
+		t.Errorf("expected SERVES edge from 127.0.0.1, got %+v", r.edges)
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	ranges := filepath.Join(t.TempDir(), "cf.txt")
+	os.WriteFile(ranges, []byte("104.16.0.0/13\n"), 0o644)
+	c := enrich.NewClassifier(nil)
+	if err := c.LoadRanges("cloudflare", ranges); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProbe(&Options{Providers: c})
+
+	hosted := func(r *results) map[string]string {
+		got := map[string]string{}
+		for _, e := range r.edges {
+			if e.Type == "HOSTED_ON" {
+				got[e.Source+" "+e.Target] = e.Attrs["via"] + "/" + e.Attrs["service"]
+			}
+		}
+		return got
+	}
+
+	// CNAME into CloudFront on a Cloudflare address
+	r := &results{now: time.Now(), nodesD: []emit.NodeDomain{{Host: "www.shop.test"}}}
+	p.attribute(r, dns.Records{CNAMEs: []string{"d1.cloudfront.net"}, A: []string{"104.16.1.1"}})
+	if d := r.nodesD[0]; d.Provider != "aws" || d.Service != "cloudfront" {
+		t.Errorf("expected the CNAME to win, got %s/%s", d.Provider, d.Service)
+	}
+	if got := hosted(r); len(got) != 1 || got["www.shop.test aws"] != "cname/cloudfront" {
+		t.Errorf("expected one HOSTED_ON via cname, got %v", got)
+	}
+
+	// no CNAME: the address range decides
+	r = &results{now: time.Now(), nodesD: []emit.NodeDomain{{Host: "blog.test"}}}
+	p.attribute(r, dns.Records{A: []string{"192.0.2.1", "104.17.2.2"}, NS: []string{"ns1.example.net"}})
+	if d := r.nodesD[0]; d.Provider != "cloudflare" {
+		t.Errorf("expected the address range to attribute the host, got %q", d.Provider)
+	}
+	if got := hosted(r); len(got) != 1 || got["blog.test cloudflare"] != "ip/" {
+		t.Errorf("expected HOSTED_ON via ip, got %v", got)
+	}
+
+	// addresses are attributed as their nodes are created
+	r = &results{now: time.Now()}
+	p.resolvesTo(r, "blog.test", "104.17.2.2", 4, false)
+	if len(r.nodesIP) != 1 || r.nodesIP[0].Provider != "cloudflare" || hosted(r)["104.17.2.2 cloudflare"] != "range/" {
+		t.Errorf("expected the IP node tagged and linked, got %+v %v", r.nodesIP, hosted(r))
+	}
+	if len(r.nodesV) != 0 {
+		t.Errorf("expected the provider node only once per run, got %+v", r.nodesV)
+	}
+
+	// a host's own NS records say nothing about who serves it
+	r = &results{now: time.Now(), nodesD: []emit.NodeDomain{{Host: "mail.test"}}}
+	p.attribute(r, dns.Records{A: []string{"192.0.2.9"}, NS: []string{"kate.ns.cloudflare.com"}})
+	if r.nodesD[0].Provider != "" || len(hosted(r)) != 0 {
+		t.Errorf("expected no attribution from NS records, got %q %v", r.nodesD[0].Provider, hosted(r))
+	}
+}
+
+func TestDNSHostedOn(t *testing.T) {
+	p := newTestProbe(&Options{Providers: enrich.NewClassifier(nil)})
+	r := &results{now: time.Now()}
+	p.dnsHostedOn(r, "shop.test", []string{"ns-1.awsdns-01.org", "ns-2.awsdns-02.net", "kate.ns.cloudflare.com", "ns1.shop.test"})
+
+	got := map[string]string{}
+	for _, e := range r.edges {
+		if e.Type != "DNS_HOSTED_ON" || e.Source != "shop.test" {
+			t.Errorf("expected only DNS_HOSTED_ON edges from the apex, got %+v", e)
+		}
+		got[e.Target] = e.Attrs["ns"]
+	}
+	want := map[string]string{"aws": "ns-1.awsdns-01.org", "cloudflare": "kate.ns.cloudflare.com"}
+	if len(got) != len(want) || got["aws"] != want["aws"] || got["cloudflare"] != want["cloudflare"] {
+		t.Errorf("expected one edge per DNS provider %v, got %v", want, got)
+	}
+	if len(r.nodesV) != 2 {
+		t.Errorf("expected both provider nodes, got %+v", r.nodesV)
+	}
+}
+
+func TestRun_EnumeratedHostsRequeued(t *testing.T) {
+	zone := &dnstest.Server{Records: map[dnstest.Key][]dnsmessage.Resource{}}
+	for _, h := range []string{"www.example.test.", "api.example.test.", "dev.example.test."} {
+		zone.Records[dnstest.Key{Name: h, Type: dnsmessage.TypeA}] = []dnsmessage.Resource{dnstest.RR(h, &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})}
+	}
+	resolver := dns.NewResolver([]string{zone.Start(t)})
+	out := make(chan emit.Batch, 64)
+	p := New("TestBot/1.0", "test", "run", nil, dedup.NewMemory(), out, &Options{
+		Resolver:       resolver,
+		Enum:           &enum.Options{Words: []string{"www", "api", "dev", "vpn"}, QPS: 1000},
+		RootCandidates: []string{"http:1"},
+	}, logging.New())
+	p.ratelim = rate.New(1000, 100)
+	refuse := func(context.Context, string, string) (net.Conn, error) { return nil, errors.New("offline") }
+	p.rob = robots.NewCache(&http.Client{Transport: &http.Transport{DialContext: refuse}}, "TestBot/1.0")
+
+	tasks := make(chan string, 2)
+	tasks <- "www.example.test"
+	tasks <- "api.example.test"
+	close(tasks)
+	p.Run(context.Background(), tasks, 2)
+	close(out)
+
+	crawled := map[string]int{}
+	for b := range out {
+		for _, n := range b.NodesD {
+			if n.RCodes != nil {
+				crawled[n.Host]++
+			}
+		}
+	}
+	want := map[string]int{"www.example.test": 1, "api.example.test": 1, "dev.example.test": 1}
+	if len(crawled) != len(want) {
+		t.Fatalf("expected %v crawled, got %v", want, crawled)
+	}
+	for h, n := range want {
+		if crawled[h] != n {
+			t.Errorf("expected %s crawled %d time(s), got %d", h, n, crawled[h])
+		}
+	}
+}
+
+func TestEnumerate_DeferredWhileWildcardUnknown(t *testing.T) {
+	records := map[dnstest.Key][]dnsmessage.Resource{
+		{Name: "www.flaky.test.", Type: dnsmessage.TypeA}: {dnstest.RR("www.flaky.test.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})},
+	}
+	failing := &dnstest.Server{Records: records, ZoneRCodes: map[string]dnsmessage.RCode{"flaky.test.": dnsmessage.RCodeServerFailure}}
+	resolver := dns.NewResolver([]string{failing.Start(t)})
+	p := newTestProbe(&Options{
+		Resolver: resolver,
+		Enum:     &enum.Options{Words: []string{"www", "dev"}, QPS: 1000},
+	})
+
+	if subs := p.enumerate(context.Background(), "api.flaky.test"); subs != nil {
+		t.Errorf("expected no subdomains while the wildcard is unknown, got %v", subs)
+	}
+	if p.dedup.Has(p.runKey("enum|flaky.test")) {
+		t.Error("expected the zone to be left for a later host, not marked enumerated")
+	}
+
+	// once the zone answers, the next host under it enumerates it
+	resolver.Servers = []string{(&dnstest.Server{Records: records}).Start(t)}
+	if subs := p.enumerate(context.Background(), "api.flaky.test"); len(subs) != 1 || subs[0] != "www.flaky.test" {
+		t.Errorf("expected www.flaky.test once the wildcard is known, got %v", subs)
+	}
+}
+
+func TestRun_RerunSharingDedup(t *testing.T) {
+	zone := &dnstest.Server{Records: map[dnstest.Key][]dnsmessage.Resource{
+		{Name: "www.example.test.", Type: dnsmessage.TypeA}: {dnstest.RR("www.example.test.", &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})},
+	}}
+	resolver := dns.NewResolver([]string{zone.Start(t)})
+	refuse := func(context.Context, string, string) (net.Conn, error) { return nil, errors.New("offline") }
+	// a store shared across runs, as Redis is
+	shared := dedup.NewMemory()
+
+	for i, run := range []string{"run-1", "run-1", "run-2"} {
+		out := make(chan emit.Batch, 16)
+		p := New("TestBot/1.0", "test", run, nil, shared, out, &Options{Resolver: resolver, RootCandidates: []string{"http:1"}}, logging.New())
+		p.ratelim = rate.New(1000, 100)
+		p.rob = robots.NewCache(&http.Client{Transport: &http.Transport{DialContext: refuse}}, "TestBot/1.0")
+		tasks := make(chan string, 1)
+		tasks <- "www.example.test"
+		close(tasks)
+		p.Run(context.Background(), tasks, 1)
+		close(out)
+
+		crawled := false
+		for b := range out {
+			for _, n := range b.NodesD {
+				crawled = crawled || n.RCodes != nil
+			}
+		}
+		// the same run never crawls a host twice; a new run does
+		if want := i != 1; crawled != want {
+			t.Errorf("pass %d (%s): expected crawled %v, got %v", i, run, want, crawled)
+		}
+	}
+}
+
+func TestRun_RegistersOffCrawlPath(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"ldhName":"example.test","entities":[{"roles":["registrar"],"vcardArray":["vcard",[["fn",{},"text","Registrar One"]]]}]}`))
+	}))
+	defer server.Close()
+	zone := &dnstest.Server{Records: map[dnstest.Key][]dnsmessage.Resource{
+		{Name: "www.example.test.", Type: dnsmessage.TypeA}: {dnstest.RR("www.example.test.", &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})},
+	}}
+	resolver := dns.NewResolver([]string{zone.Start(t)})
+	out := make(chan emit.Batch, 64)
+	p := New("TestBot/1.0", "test", "run", nil, dedup.NewMemory(), out, &Options{
+		Resolver:       resolver,
+		RDAP:           enrich.Bootstrap{"test": server.URL + "/"},
+		RDAPQPS:        100,
+		RootCandidates: []string{"http:1"},
+	}, logging.New())
+	p.ratelim = rate.New(1000, 100)
+	refuse := func(context.Context, string, string) (net.Conn, error) { return nil, errors.New("offline") }
+	p.rob = robots.NewCache(&http.Client{Transport: &http.Transport{DialContext: refuse}}, "TestBot/1.0")
+
+	tasks := make(chan string, 1)
+	tasks <- "www.example.test"
+	close(tasks)
+	finished := make(chan struct{})
+	go func() { p.Run(context.Background(), tasks, 1); close(finished) }()
+
+	// the crawl's batch arrives while the registry has yet to answer
+	select {
+	case b := <-out:
+		if len(b.NodesReg) != 0 {
+			t.Errorf("expected the crawl batch without a registration, got %+v", b.NodesReg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the crawl to finish while the registry lookup is pending")
+	}
+	select {
+	case <-finished:
+		t.Fatal("expected Run to wait for the pending registration")
+	default:
+	}
+	close(release)
+	<-finished
+	close(out)
+
+	var regs []emit.NodeRegistration
+	for b := range out {
+		regs = append(regs, b.NodesReg...)
+	}
+	if len(regs) != 1 || regs[0].Domain != "example.test" || regs[0].Registrar != "Registrar One" {
+		t.Errorf("expected the registration in its own batch, got %+v", regs)
+	}
+}
+
+func TestTransferZone_Nodes(t *testing.T) {
+	n := dnsmessage.MustNewName
+	soa := dnstest.RR("corp.test.", &dnsmessage.SOAResource{NS: n("ns1.corp.test."), MBox: n("hostmaster.corp.test."), Serial: 7})
+	zone := &dnstest.Server{Records: map[dnstest.Key][]dnsmessage.Resource{
+		{Name: "ns1.corp.test.", Type: dnsmessage.TypeA}: {dnstest.RR("ns1.corp.test.", &dnsmessage.AResource{A: [4]byte{192, 0, 2, 53}})},
+		{Name: "corp.test.", Type: dnsmessage.TypeAXFR}: {
+			soa,
+			dnstest.RR("intranet.corp.test.", &dnsmessage.AResource{A: [4]byte{10, 0, 0, 5}}),
+			dnstest.RR("vpn.corp.test.", &dnsmessage.CNAMEResource{CNAME: n("gw.vendor.net.")}),
+			dnstest.RR("vpn.corp.test.", &dnsmessage.TXTResource{TXT: []string{"owner=it"}}),
+			soa,
+		},
+	}}
+	addr := zone.Start(t)
+	resolver := dns.NewResolver([]string{addr})
+	resolver.Dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	p := newTestProbe(&Options{Resolver: resolver, AXFR: true})
+	r := &results{now: time.Now()}
+
+	if st := p.transferZone(context.Background(), r, "corp.test", "ns1.corp.test"); st != dns.AXFRAllowed {
+		t.Fatalf("expected the transfer to be allowed, got %s", st)
+	}
+	nodes := map[string]int{}
+	for _, d := range r.nodesD {
+		nodes[d.Host]++
+	}
+	for _, h := range []string{"intranet.corp.test", "vpn.corp.test", "gw.vendor.net"} {
+		if nodes[h] != 1 {
+			t.Errorf("expected one node for %s, got %v", h, nodes)
+		}
+	}
+	if len(r.finds) != 1 || r.finds[0].Kind != "AXFR_ALLOWED" {
+		t.Errorf("expected an AXFR_ALLOWED finding, got %+v", r.finds)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path != "/domain/shop.test" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"ldhName":"shop.test","events":[{"eventAction":"registration","eventDate":"2001-02-03T00:00:00Z"}],
+			"entities":[{"roles":["registrar"],"publicIds":[{"type":"IANA Registrar ID","identifier":"42"}],
+			"vcardArray":["vcard",[["fn",{},"text","Registrar One"]]]}]}`))
+	}))
+	defer server.Close()
+
+	p := newTestProbe(&Options{RDAP: enrich.Bootstrap{"test": server.URL + "/"}, RDAPQPS: 100})
+	r := &results{now: time.Now()}
+	p.register(context.Background(), r, "shop.test")
+	p.register(context.Background(), r, "gone.test")
+
+	if len(r.nodesR) != 1 || r.nodesR[0].Registrar != "Registrar One" || r.nodesR[0].Created == nil || r.nodesR[0].Expires != nil {
+		t.Errorf("expected one registration with only a creation date, got %+v", r.nodesR)
+	}
+	if len(r.nodesG) != 1 || r.nodesG[0].IANAID != "42" {
+		t.Errorf("expected the registrar node, got %+v", r.nodesG)
+	}
+	if len(r.edges) != 1 || r.edges[0].Type != "REGISTERED_WITH" || r.edges[0].Source != "shop.test" || r.edges[0].Target != "Registrar One" {
+		t.Errorf("expected shop.test REGISTERED_WITH Registrar One, got %+v", r.edges)
+	}
+	if hits != 2 {
+		t.Errorf("expected one request per apex, got %d", hits)
+	}
+}
+
+func TestCollectMXCerts_RetriesAfterFailure(t *testing.T) {
+	tlsServer := httptest.NewUnstartedServer(http.NotFoundHandler())
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	cert := tlsServer.TLS.Certificates[0]
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var up, accepted int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func(c net.Conn) {
+				defer c.Close()
+				if atomic.LoadInt32(&up) == 0 {
+					return
+				}
+				c.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+				buf := make([]byte, 64)
+				c.Read(buf)
+				c.Write([]byte("a1 OK Begin TLS negotiation now\r\n"))
+				tls.Server(c, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+			}(c)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	saved := mailServices
+	mailServices = []struct{ proto, port string }{{tlsinfo.ProtoIMAP, port}}
+	defer func() { mailServices = saved }()
+
+	p := newTestProbe(nil)
+	r := &results{now: time.Now()}
+	p.collectMXCerts(context.Background(), r, []string{"127.0.0.1"})
+	if len(r.edges) != 0 {
+		t.Fatalf("expected no certificate from a failing server, got %+v", r.edges)
+	}
+
+	atomic.StoreInt32(&up, 1)
+	p.collectMXCerts(context.Background(), r, []string{"127.0.0.1"})
+	if len(r.edges) != 1 || r.edges[0].Type != "USES_CERT" || len(r.nodesC) != 1 {
+		t.Fatalf("expected a retry to record the certificate, got edges %+v", r.edges)
+	}
+
+	p.collectMXCerts(context.Background(), r, []string{"127.0.0.1"})
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Errorf("expected no handshake once the certificate is known, got %d connections", n)
+	}
+}
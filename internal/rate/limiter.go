@@ -52,8 +52,9 @@ func (p *PerHost) cleanup() {
 	}
 }
 
-func (p *PerHost) Allow(host string) bool {
+func (p *PerHost) get(host string) *rate.Limiter {
 	p.mu.Lock()
+	defer p.mu.Unlock()
 	entry, ok := p.m[host]
 	if !ok { 
 		entry = &limitEntry{
@@ -64,22 +65,19 @@ func (p *PerHost) Allow(host string) bool {
 	} else {
 		entry.lastUsed = time.Now()
 	}
-	p.mu.Unlock()
-	return entry.limiter.Allow()
+	return entry.limiter
+}
+
+func (p *PerHost) Allow(host string) bool {
+	return p.get(host).Allow()
 }
 
 func (p *PerHost) Wait(host string) {
-	p.mu.Lock()
-	entry, ok := p.m[host]
-	if !ok { 
-		entry = &limitEntry{
-			limiter: rate.NewLimiter(rate.Limit(p.perSecond), p.burst),
-			lastUsed: time.Now(),
-		}
-		p.m[host] = entry
-	} else {
-		entry.lastUsed = time.Now()
-	}
-	p.mu.Unlock()
-	_ = entry.limiter.Wait(context.Background())
+	_ = p.WaitContext(context.Background(), host)
+}
+
+// WaitContext blocks until host may be contacted or ctx ends, returning
+// ctx's error in the latter case.
+func (p *PerHost) WaitContext(ctx context.Context, host string) error {
+	return p.get(host).Wait(ctx)
 }
@@ -1,6 +1,7 @@
 package rate
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -105,4 +106,21 @@ func BenchmarkPerHost_Allow(b *testing.B) {
 			limiter.Allow(string(rune(i % 100)))
 		}
 	})
-}
\ No newline at end of file
+}
+
+func TestPerHost_WaitContextCancelled(t *testing.T) {
+	limiter := New(0.1, 1) // one request every ten seconds
+	if err := limiter.WaitContext(context.Background(), "host1"); err != nil {
+		t.Fatalf("expected first wait to pass, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := limiter.WaitContext(ctx, "host1"); err == nil {
+		t.Error("expected an error once the context ends")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected WaitContext to return with its context, took %v", elapsed)
+	}
+}
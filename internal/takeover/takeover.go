@@ -0,0 +1,146 @@
+package takeover
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gustycube/spyder/internal/dns"
+	"golang.org/x/net/dns/dnsmessage"
+	"gopkg.in/yaml.v3"
+)
+
+// Finding kinds.
+const (
+	// KindCandidate is a CNAME into a known service whose target is
+	// unclaimed, judged by NXDOMAIN or the service's "no such site" page.
+	KindCandidate = "TAKEOVER_CANDIDATE"
+	// KindDangling is a CNAME whose target does not exist but whose service
+	// is unknown or not known to be claimable that way.
+	KindDangling = "DANGLING_CNAME"
+)
+
+// Signature describes a service whose hostnames can be claimed by anyone
+// once the owner releases them.
+type Signature struct {
+	Service string `yaml:"service" json:"service"`
+	// CNAMEs are hostname suffixes that identify the service.
+	CNAMEs []string `yaml:"cnames" json:"cnames"`
+	// Fingerprints are body substrings the service serves for unclaimed
+	// names.
+	Fingerprints []string `yaml:"fingerprints" json:"fingerprints"`
+	// NXDomain marks services whose unclaimed names stop resolving, so an
+	// NXDOMAIN target alone is enough evidence.
+	NXDomain bool `yaml:"nxdomain" json:"nxdomain"`
+}
+
+// Matches reports whether target belongs to the service.
+func (s Signature) Matches(target string) bool {
+	target = strings.ToLower(target)
+	for _, c := range s.CNAMEs {
+		c = strings.ToLower(strings.TrimPrefix(c, "."))
+		if target == c || strings.HasSuffix(target, "."+c) { return true }
+	}
+	return false
+}
+
+// DefaultSignatures covers widely documented takeover-prone services.
+var DefaultSignatures = []Signature{
+	{Service: "aws-s3", CNAMEs: []string{"s3.amazonaws.com", "s3-website.us-east-1.amazonaws.com", "s3-website-us-east-1.amazonaws.com"}, Fingerprints: []string{"NoSuchBucket", "The specified bucket does not exist"}},
+	{Service: "azure", CNAMEs: []string{"azurewebsites.net", "cloudapp.net", "cloudapp.azure.com", "trafficmanager.net", "blob.core.windows.net", "azureedge.net"}, NXDomain: true},
+	{Service: "github-pages", CNAMEs: []string{"github.io"}, Fingerprints: []string{"There isn't a GitHub Pages site here."}},
+	{Service: "heroku", CNAMEs: []string{"herokuapp.com", "herokudns.com"}, Fingerprints: []string{"No such app", "herokucdn.com/error-pages/no-such-app.html"}},
+	{Service: "fastly", CNAMEs: []string{"fastly.net"}, Fingerprints: []string{"Fastly error: unknown domain"}},
+	{Service: "shopify", CNAMEs: []string{"myshopify.com"}, Fingerprints: []string{"Sorry, this shop is currently unavailable."}},
+	{Service: "netlify", CNAMEs: []string{"netlify.app", "netlify.com"}, Fingerprints: []string{"Not Found - Request ID:"}},
+	{Service: "vercel", CNAMEs: []string{"vercel.app", "now.sh"}, Fingerprints: []string{"The deployment could not be found on Vercel."}},
+	{Service: "bitbucket", CNAMEs: []string{"bitbucket.io"}, Fingerprints: []string{"Repository not found"}},
+	{Service: "ghost", CNAMEs: []string{"ghost.io"}, Fingerprints: []string{"The thing you were looking for is no longer here"}},
+	{Service: "pantheon", CNAMEs: []string{"pantheonsite.io"}, Fingerprints: []string{"The gods are wise, but do not know of the site which you seek."}},
+	{Service: "zendesk", CNAMEs: []string{"zendesk.com"}, Fingerprints: []string{"Help Center Closed"}},
+	{Service: "readme", CNAMEs: []string{"readme.io"}, Fingerprints: []string{"Project doesnt exist... yet!"}},
+	{Service: "surge", CNAMEs: []string{"surge.sh"}, Fingerprints: []string{"project not found"}},
+	{Service: "cloudfront", CNAMEs: []string{"cloudfront.net"}, Fingerprints: []string{"The request could not be satisfied", "ERROR: The request could not be satisfied"}},
+	{Service: "elasticbeanstalk", CNAMEs: []string{"elasticbeanstalk.com"}, NXDomain: true},
+}
+
+// LoadSignatures reads a YAML or JSON list of signatures.
+func LoadSignatures(path string) ([]Signature, error) {
+	b, err := os.ReadFile(path)
+	if err != nil { return nil, err }
+	var sigs []Signature
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(b, &sigs)
+	default:
+		err = yaml.Unmarshal(b, &sigs)
+	}
+	if err != nil { return nil, fmt.Errorf("parse takeover signatures %s: %w", path, err) }
+	return sigs, nil
+}
+
+// Candidate is a takeover risk with the evidence behind it.
+type Candidate struct {
+	Kind     string
+	Service  string
+	Target   string
+	Evidence []string
+}
+
+// Detector checks CNAME chains against a signature set.
+type Detector struct {
+	sigs     []Signature
+	resolver *dns.Resolver
+}
+
+// New returns a Detector. nil sigs selects DefaultSignatures.
+func New(sigs []Signature, resolver *dns.Resolver) *Detector {
+	if sigs == nil { sigs = DefaultSignatures }
+	return &Detector{sigs: sigs, resolver: resolver}
+}
+
+// Check inspects a host's CNAME chain and returns a candidate, or nil when
+// nothing looks claimable. The last hop is checked for NXDOMAIN; when a hop
+// matches a signature with fingerprints, body, the root page the caller
+// fetched for the host (empty when it fetched none), is compared against
+// them. Check makes no HTTP requests of its own. An NXDOMAIN target is a
+// candidate only for services whose signature says so; otherwise it is
+// reported as dangling.
+func (d *Detector) Check(ctx context.Context, chain []string, body string) *Candidate {
+	if len(chain) == 0 { return nil }
+	target := chain[len(chain)-1]
+	var sig *Signature
+	for _, hop := range chain {
+		for i := range d.sigs {
+			if d.sigs[i].Matches(hop) { sig, target = &d.sigs[i], hop; break }
+		}
+		if sig != nil { break }
+	}
+
+	last := chain[len(chain)-1]
+	if d.nxdomain(ctx, last) {
+		evidence := []string{"nxdomain:" + last}
+		if sig != nil && sig.NXDomain {
+			return &Candidate{Kind: KindCandidate, Service: sig.Service, Target: target, Evidence: append([]string{"cname:" + target}, evidence...)}
+		}
+		c := &Candidate{Kind: KindDangling, Target: last, Evidence: evidence}
+		if sig != nil { c.Service = sig.Service }
+		return c
+	}
+	if sig == nil || len(sig.Fingerprints) == 0 { return nil }
+	for _, fp := range sig.Fingerprints {
+		if strings.Contains(body, fp) {
+			return &Candidate{Kind: KindCandidate, Service: sig.Service, Target: target, Evidence: []string{"cname:" + target, "fingerprint:" + fp}}
+		}
+	}
+	return nil
+}
+
+// nxdomain reports whether name answers NXDOMAIN.
+func (d *Detector) nxdomain(ctx context.Context, name string) bool {
+	ans, err := d.resolver.Query(ctx, name, dnsmessage.TypeA)
+	return err == nil && ans.RCode == dnsmessage.RCodeNameError
+}
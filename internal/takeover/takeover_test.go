@@ -0,0 +1,99 @@
+package takeover
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gustycube/spyder/internal/dns"
+	"github.com/gustycube/spyder/internal/dns/dnstest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// nxServer answers NXDOMAIN for the names in nx and an empty NOERROR for
+// everything else.
+func nxServer(t *testing.T, nx ...string) *dns.Resolver {
+	t.Helper()
+	s := &dnstest.Server{RCodes: map[string]dnsmessage.RCode{}}
+	for _, n := range nx {
+		s.RCodes[n+"."] = dnsmessage.RCodeNameError
+	}
+	return dns.NewResolver([]string{s.Start(t)})
+}
+
+func TestCheck_Fingerprint(t *testing.T) {
+	d := New(nil, nxServer(t))
+
+	c := d.Check(context.Background(), []string{"example.github.io"}, "<h1>404</h1> There isn't a GitHub Pages site here.")
+	if c == nil {
+		t.Fatal("expected a takeover candidate")
+	}
+	if c.Kind != KindCandidate || c.Service != "github-pages" || c.Target != "example.github.io" {
+		t.Errorf("expected github-pages candidate, got %+v", c)
+	}
+	if len(c.Evidence) != 2 || c.Evidence[1] != "fingerprint:There isn't a GitHub Pages site here." {
+		t.Errorf("expected cname and fingerprint evidence, got %v", c.Evidence)
+	}
+}
+
+func TestCheck_ClaimedSite(t *testing.T) {
+	d := New(nil, nxServer(t))
+	if c := d.Check(context.Background(), []string{"example.github.io"}, "<h1>Welcome to our docs</h1>"); c != nil {
+		t.Errorf("expected no candidate for a live site, got %+v", c)
+	}
+	if c := d.Check(context.Background(), []string{"example.github.io"}, ""); c != nil {
+		t.Errorf("expected no candidate without a fetched page, got %+v", c)
+	}
+}
+
+func TestCheck_NXDomain(t *testing.T) {
+	r := nxServer(t, "gone.azurewebsites.net", "old.unknown-host.net", "bucket.s3.amazonaws.com")
+	d := New(nil, r)
+
+	c := d.Check(context.Background(), []string{"app.trafficmanager.net", "gone.azurewebsites.net"}, "")
+	if c == nil || c.Kind != KindCandidate || c.Service != "azure" {
+		t.Errorf("expected azure candidate from NXDOMAIN, got %+v", c)
+	}
+
+	c = d.Check(context.Background(), []string{"old.unknown-host.net"}, "")
+	if c == nil || c.Kind != KindDangling || c.Evidence[0] != "nxdomain:old.unknown-host.net" {
+		t.Errorf("expected dangling CNAME, got %+v", c)
+	}
+
+	// S3 needs the NoSuchBucket page; NXDOMAIN alone is only dangling
+	c = d.Check(context.Background(), []string{"bucket.s3.amazonaws.com"}, "")
+	if c == nil || c.Kind != KindDangling || c.Service != "aws-s3" {
+		t.Errorf("expected dangling aws-s3 CNAME, got %+v", c)
+	}
+}
+
+func TestCheck_NoMatch(t *testing.T) {
+	d := New(nil, nxServer(t))
+	if c := d.Check(context.Background(), []string{"www.example.net"}, "NoSuchBucket"); c != nil {
+		t.Errorf("expected no candidate for an unknown live target, got %+v", c)
+	}
+	if c := d.Check(context.Background(), nil, "NoSuchBucket"); c != nil {
+		t.Errorf("expected no candidate without a CNAME, got %+v", c)
+	}
+}
+
+func TestLoadSignatures(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sigs.yaml")
+	os.WriteFile(path, []byte("- service: acme\n  cnames: [acme-sites.io]\n  fingerprints: [\"site not claimed\"]\n"), 0o644)
+
+	sigs, err := LoadSignatures(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sigs) != 1 || sigs[0].Service != "acme" || !sigs[0].Matches("x.ACME-sites.io") || sigs[0].Matches("acme-sites.io.evil.com") {
+		t.Errorf("expected acme signature matching its suffix only, got %+v", sigs)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte("{"), 0o644)
+	if _, err := LoadSignatures(bad); err == nil {
+		t.Error("expected a parse error")
+	}
+}
@@ -0,0 +1,64 @@
+package tlsinfo
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fingerprintProbes are the ClientHello variants sent by Fingerprint. Each
+// narrows what the client offers so the server's choices reveal its stack:
+// version support, cipher preference within a family, ALPN handling and
+// curve support.
+var fingerprintProbes = []tls.Config{
+	{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12, NextProtos: []string{"h2", "http/1.1"}},
+	{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12, NextProtos: []string{"http/1.1"}},
+	{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305}},
+	{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}},
+	{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA}},
+	{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA, tls.TLS_RSA_WITH_AES_256_CBC_SHA}},
+	{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12, CurvePreferences: []tls.CurveID{tls.CurveP384, tls.CurveP521}},
+	{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11},
+	{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13, NextProtos: []string{"h2", "http/1.1"}},
+	{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13, CurvePreferences: []tls.CurveID{tls.CurveP256}},
+}
+
+// Fingerprint computes a JARM-style fingerprint of host's TLS stack: each
+// probe's negotiated version, cipher suite and ALPN are concatenated and
+// hashed. Failed probes contribute an empty entry, so servers refusing the
+// same probes still cluster together. It returns "" when every probe fails.
+func Fingerprint(ctx context.Context, host string, opts Options) string {
+	ctx, span := otel.Tracer("spyder/tlsinfo").Start(ctx, "Fingerprint", trace.WithAttributes(attribute.String("host", host)))
+	defer span.End()
+	if opts.Timeout == 0 { opts.Timeout = 5 * time.Second }
+	parts := make([]string, len(fingerprintProbes))
+	answered := false
+	for i := range fingerprintProbes {
+		if ctx.Err() != nil { return "" }
+		cfg := fingerprintProbes[i].Clone()
+		cfg.ServerName = serverName(host, opts)
+		cfg.InsecureSkipVerify = true
+		cs, _, err := handshake(ctx, host, opts, cfg)
+		if err != nil { continue }
+		answered = true
+		parts[i] = fmt.Sprintf("%04x|%04x|%s", cs.Version, cs.CipherSuite, cs.NegotiatedProtocol)
+	}
+	if !answered { return "" }
+	sum := sha256.Sum256([]byte(strings.Join(parts, ",")))
+	return hex.EncodeToString(sum[:16])
+}
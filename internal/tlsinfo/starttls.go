@@ -0,0 +1,57 @@
+package tlsinfo
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// STARTTLS protocols understood by Options.StartTLS. Mail servers
+// routinely present certificates that would not verify; as with every
+// fetch the certificate is collected and verified afterwards, not trusted.
+const (
+	ProtoSMTP = "smtp"
+	ProtoIMAP = "imap"
+	ProtoPOP3 = "pop3"
+)
+
+// startTLS runs the plaintext exchange that precedes the TLS handshake.
+func startTLS(conn net.Conn, proto string) error {
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	switch proto {
+	case ProtoSMTP:
+		if _, _, err := tp.ReadResponse(220); err != nil { return err }
+		if _, err := fmt.Fprintf(conn, "EHLO spyder-probe\r\n"); err != nil { return err }
+		_, msg, err := tp.ReadResponse(250)
+		if err != nil { return err }
+		if !strings.Contains(strings.ToUpper(msg), "STARTTLS") { return fmt.Errorf("smtp: STARTTLS not offered") }
+		if _, err := fmt.Fprintf(conn, "STARTTLS\r\n"); err != nil { return err }
+		_, _, err = tp.ReadResponse(220)
+		return err
+	case ProtoIMAP:
+		if err := expectLine(tp, "* OK"); err != nil { return err }
+		if _, err := fmt.Fprintf(conn, "a1 STARTTLS\r\n"); err != nil { return err }
+		for {
+			line, err := tp.ReadLine()
+			if err != nil { return err }
+			if strings.HasPrefix(line, "a1 ") {
+				if !strings.HasPrefix(line, "a1 OK") { return fmt.Errorf("imap: %s", line) }
+				return nil
+			}
+		}
+	case ProtoPOP3:
+		if err := expectLine(tp, "+OK"); err != nil { return err }
+		if _, err := fmt.Fprintf(conn, "STLS\r\n"); err != nil { return err }
+		return expectLine(tp, "+OK")
+	}
+	return fmt.Errorf("unsupported starttls protocol: %s", proto)
+}
+
+func expectLine(tp *textproto.Reader, prefix string) error {
+	line, err := tp.ReadLine()
+	if err != nil { return err }
+	if !strings.HasPrefix(line, prefix) { return fmt.Errorf("unexpected response: %s", line) }
+	return nil
+}
@@ -1,32 +1,199 @@
 package tlsinfo
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"fmt"
+	"math"
 	"net"
+	"os"
 	"time"
 
+	"github.com/gustycube/spyder/internal/egress"
 	"github.com/gustycube/spyder/internal/emit"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func FetchCert(host string) (*emit.NodeCert, error) {
-	d := &tls.Dialer{Config: &tls.Config{ServerName: host}}
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
-	defer cancel()
-	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, "443"))
+// Options controls a handshake. The zero value connects directly to
+// host:443 with SNI set to host and an 8 second timeout.
+type Options struct {
+	// Timeout bounds the whole fetch, on top of the caller's context.
+	Timeout time.Duration
+	// Port defaults to 443.
+	Port string
+	// SNI overrides the server name sent; empty sends host.
+	SNI string
+	// NoSNI sends no server_name extension at all, which reveals the
+	// default certificate of a shared IP.
+	NoSNI bool
+	// ALPN protocols to offer; nil offers h2 and http/1.1.
+	ALPN []string
+	// Dial makes the TCP connection; nil dials directly.
+	Dial egress.DialFunc
+	// Roots verifies the presented chain; nil uses the system pool.
+	Roots *x509.CertPool
+	// StartTLS upgrades a plaintext mail connection first (ProtoSMTP,
+	// ProtoIMAP or ProtoPOP3).
+	StartTLS string
+}
+
+// FetchCert returns the leaf certificate host presents, whether or not it
+// verifies.
+func FetchCert(ctx context.Context, host string, opts Options) (*emit.NodeCert, error) {
+	cert, _, err := Observe(ctx, host, opts)
+	return cert, err
+}
+
+// Observe handshakes with host and returns the leaf certificate together
+// with the negotiated version, cipher suite, ALPN protocol, whether an
+// OCSP response was stapled and, when opts.Dial pins the connection with
+// egress.WithIPs, the address dialed. The handshake never fails on the certificate;
+// instead the chain is verified afterwards against opts.Roots and the
+// outcome recorded on the observation.
+func Observe(ctx context.Context, host string, opts Options) (*emit.NodeCert, *emit.NodeTLS, error) {
+	port := opts.Port
+	if port == "" { port = "443" }
+	ctx, span := otel.Tracer("spyder/tlsinfo").Start(ctx, "Observe",
+		trace.WithAttributes(attribute.String("host", host), attribute.String("port", port), attribute.String("sni", serverName(host, opts))))
+	defer span.End()
+	cfg := &tls.Config{ServerName: serverName(host, opts), NextProtos: opts.ALPN, InsecureSkipVerify: true}
+	if cfg.NextProtos == nil && opts.StartTLS == "" { cfg.NextProtos = []string{"h2", "http/1.1"} }
+	cs, ip, err := handshake(ctx, host, opts, cfg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	obs := &emit.NodeTLS{
+		Host:        host,
+		IP:          ip,
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+		OCSPStapled: len(cs.OCSPResponse) > 0,
+		ObservedAt:  now,
+	}
+	if len(cs.PeerCertificates) > 0 {
+		v := Verify(cs.PeerCertificates, host, opts.Roots, now)
+		obs.Validation, obs.Trusted, obs.NameMatch = v.Status, v.Trusted, v.NameMatch
+	}
+	return leafCert(cs), obs, nil
+}
+
+func serverName(host string, opts Options) string {
+	switch {
+	case opts.NoSNI:
+		return ""
+	case opts.SNI != "":
+		return opts.SNI
+	}
+	return host
+}
+
+// Validation statuses, in the order Verify reports them when a chain has
+// several problems.
+const (
+	StatusValid        = "valid"
+	StatusExpired      = "expired"
+	StatusNotYetValid  = "not_yet_valid"
+	StatusSelfSigned   = "self_signed"
+	StatusUntrusted    = "untrusted"
+	StatusNameMismatch = "name_mismatch"
+)
+
+// Verification is the outcome of checking a presented chain.
+type Verification struct {
+	Status    string
+	Trusted   bool
+	NameMatch bool
+}
+
+// Verify checks chain (leaf first) for host at time now. Trust is judged
+// independently of the validity period, so an expired certificate from a
+// public CA is reported as expired rather than untrusted.
+func Verify(chain []*x509.Certificate, host string, roots *x509.CertPool, now time.Time) Verification {
+	leaf := chain[0]
+	inter := x509.NewCertPool()
+	for _, c := range chain[1:] { inter.AddCert(c) }
+	at := now
+	if at.After(leaf.NotAfter) || at.Before(leaf.NotBefore) { at = leaf.NotAfter.Add(-time.Second) }
+	_, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: inter, CurrentTime: at})
+	v := Verification{Trusted: err == nil, NameMatch: leaf.VerifyHostname(host) == nil}
+	switch {
+	case now.After(leaf.NotAfter):
+		v.Status = StatusExpired
+	case now.Before(leaf.NotBefore):
+		v.Status = StatusNotYetValid
+	case !v.Trusted && selfSigned(leaf):
+		v.Status = StatusSelfSigned
+	case !v.Trusted:
+		v.Status = StatusUntrusted
+	case !v.NameMatch:
+		v.Status = StatusNameMismatch
+	default:
+		v.Status = StatusValid
+	}
+	return v
+}
+
+// LoadRoots reads a PEM bundle to verify against instead of the system pool.
+func LoadRoots(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
 	if err != nil { return nil, err }
-	defer conn.Close()
-	cs := conn.(*tls.Conn).ConnectionState()
-	if len(cs.PeerCertificates) == 0 { return nil, nil }
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) { return nil, fmt.Errorf("no certificates found in %s", path) }
+	return pool, nil
+}
+
+func selfSigned(c *x509.Certificate) bool {
+	// CheckSignatureFrom would reject leaves without the CA flag, which is
+	// how most self-signed server certificates are issued
+	return bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature) == nil
+}
+
+// leafCert describes the peer's leaf certificate, or nil if none was sent.
+func leafCert(cs tls.ConnectionState) *emit.NodeCert {
+	if len(cs.PeerCertificates) == 0 { return nil }
 	leaf := cs.PeerCertificates[0]
 	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
 	return &emit.NodeCert{
-		SPKI:      base64.StdEncoding.EncodeToString(spki[:]),
-		SubjectCN: leaf.Subject.CommonName,
-		IssuerCN:  leaf.Issuer.CommonName,
-		NotBefore: leaf.NotBefore,
-		NotAfter:  leaf.NotAfter,
-	}, nil
+		SPKI:         base64.StdEncoding.EncodeToString(spki[:]),
+		SubjectCN:    leaf.Subject.CommonName,
+		IssuerCN:     leaf.Issuer.CommonName,
+		NotBefore:    leaf.NotBefore,
+		NotAfter:     leaf.NotAfter,
+		DaysToExpiry: int(math.Floor(time.Until(leaf.NotAfter).Hours() / 24)),
+		SelfSigned:   selfSigned(leaf),
+	}
+}
+
+// handshake dials host per opts and completes a TLS handshake with cfg. It
+// also returns the IP the connection was pinned to, if any.
+func handshake(ctx context.Context, host string, opts Options, cfg *tls.Config) (tls.ConnectionState, string, error) {
+	timeout := opts.Timeout
+	if timeout == 0 { timeout = 8 * time.Second }
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dial, port := opts.Dial, opts.Port
+	if dial == nil { dial = (&net.Dialer{}).DialContext }
+	if port == "" { port = "443" }
+	raw, err := dial(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil { return tls.ConnectionState{}, "", err }
+	defer raw.Close()
+	if opts.StartTLS != "" {
+		if dl, ok := ctx.Deadline(); ok { raw.SetDeadline(dl) }
+		if err := startTLS(raw, opts.StartTLS); err != nil { return tls.ConnectionState{}, "", err }
+		raw.SetDeadline(time.Time{})
+	}
+	conn := tls.Client(raw, cfg)
+	if err := conn.HandshakeContext(ctx); err != nil { return tls.ConnectionState{}, "", err }
+	return conn.ConnectionState(), egress.PinnedIP(raw), nil
 }
@@ -0,0 +1,352 @@
+package tlsinfo
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gustycube/spyder/internal/egress"
+)
+
+// tlsServer runs a local TLS server with cfg and returns a dial function that
+// routes every handshake to it.
+func tlsServer(t *testing.T, cfg *tls.Config) egress.DialFunc {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = cfg
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	addr := server.Listener.Addr().String()
+	return func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	modern := tlsServer(t, &tls.Config{})
+	legacy := tlsServer(t, &tls.Config{MaxVersion: tls.VersionTLS12})
+
+	fp1 := Fingerprint(context.Background(), "example.com", Options{Dial: modern})
+	fp2 := Fingerprint(context.Background(), "example.com", Options{Dial: modern})
+	if fp1 == "" {
+		t.Fatal("expected a fingerprint from a live TLS server")
+	}
+	if fp1 != fp2 {
+		t.Errorf("expected stable fingerprint, got %s and %s", fp1, fp2)
+	}
+	if fp3 := Fingerprint(context.Background(), "example.com", Options{Dial: legacy}); fp3 == fp1 {
+		t.Errorf("expected TLS 1.2-only server to fingerprint differently, both %s", fp1)
+	}
+}
+
+func TestFingerprint_Unreachable(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	dial := func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	if fp := Fingerprint(context.Background(), "example.com", Options{Dial: dial}); fp != "" {
+		t.Errorf("expected empty fingerprint for unreachable host, got %s", fp)
+	}
+}
+
+// newCert returns a throwaway self-signed certificate for names.
+func newCert(t *testing.T, notAfter time.Time, names ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// mailServer runs a plaintext mail stand-in that upgrades to TLS after the
+// protocol's STARTTLS exchange.
+func mailServer(t *testing.T, proto string, cert tls.Certificate) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				switch proto {
+				case ProtoSMTP:
+					io.WriteString(c, "220 mx.test ESMTP\r\n")
+					r.ReadString('\n')
+					io.WriteString(c, "250-mx.test\r\n250-PIPELINING\r\n250 STARTTLS\r\n")
+					r.ReadString('\n')
+					io.WriteString(c, "220 2.0.0 Ready to start TLS\r\n")
+				case ProtoIMAP:
+					io.WriteString(c, "* OK IMAP4rev1 ready\r\n")
+					r.ReadString('\n')
+					io.WriteString(c, "a1 OK Begin TLS negotiation now\r\n")
+				case ProtoPOP3:
+					io.WriteString(c, "+OK POP3 ready\r\n")
+					r.ReadString('\n')
+					io.WriteString(c, "+OK Begin TLS\r\n")
+				}
+				tls.Server(c, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+			}(c)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func TestFetchCert_StartTLS(t *testing.T) {
+	cert := newCert(t, time.Now().Add(24*time.Hour), "mx.test")
+	for _, proto := range []string{ProtoSMTP, ProtoIMAP, ProtoPOP3} {
+		t.Run(proto, func(t *testing.T) {
+			port := mailServer(t, proto, cert)
+			got, err := FetchCert(context.Background(), "127.0.0.1", Options{Port: port, StartTLS: proto})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got == nil || got.SubjectCN != "mx.test" {
+				t.Errorf("expected certificate for mx.test, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestFetchCert_StartTLSNotOffered(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.WriteString(c, "220 mx.test ESMTP\r\n")
+		bufio.NewReader(c).ReadString('\n')
+		io.WriteString(c, "250-mx.test\r\n250 SIZE 10240000\r\n")
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	if _, err := FetchCert(context.Background(), "127.0.0.1", Options{Port: port, StartTLS: ProtoSMTP}); err == nil {
+		t.Error("expected an error when STARTTLS is not offered")
+	}
+}
+
+func TestObserve(t *testing.T) {
+	cert := newCert(t, time.Now().Add(24*time.Hour), "example.com")
+	cert.OCSPStaple = []byte{0x30, 0x03, 0x0a, 0x01, 0x00}
+	dial := tlsServer(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	})
+
+	leaf, obs, err := Observe(context.Background(), "example.com", Options{Dial: dial})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if obs.Host != "example.com" || obs.Version != "TLS 1.2" || obs.CipherSuite != "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256" {
+		t.Errorf("expected TLS 1.2 with the only offered suite, got %+v", obs)
+	}
+	if obs.ALPN != "h2" || !obs.OCSPStapled {
+		t.Errorf("expected h2 with a stapled OCSP response, got alpn %q stapled %v", obs.ALPN, obs.OCSPStapled)
+	}
+	if leaf == nil || leaf.SubjectCN != "example.com" || leaf.SPKI == "" {
+		t.Errorf("expected the example.com leaf, got %+v", leaf)
+	}
+
+	// a certificate nothing trusts is still observed, not a handshake error
+	if _, obs, err = Observe(context.Background(), "example.com", Options{Dial: dial, Roots: x509.NewCertPool()}); err != nil || obs.Trusted {
+		t.Errorf("expected an untrusted observation, got %+v, %v", obs, err)
+	}
+}
+
+func TestObserve_PinnedIP(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{newCert(t, time.Now().Add(24*time.Hour), "example.com")}}
+	server.StartTLS()
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	d, _ := egress.New(egress.Config{})
+	pinned := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return d.DialContext(egress.WithIPs(ctx, "example.com", []string{"127.0.0.1"}), network, addr)
+	}
+
+	_, obs, err := Observe(context.Background(), "example.com", Options{Port: port, Dial: pinned})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if obs.IP != "127.0.0.1" {
+		t.Errorf("expected the pinned address on the observation, got %q", obs.IP)
+	}
+
+	// an unpinned dial has no address to report
+	if _, obs, err = Observe(context.Background(), "example.com", Options{Dial: tlsServer(t, server.TLS)}); err != nil || obs.IP != "" {
+		t.Errorf("expected no address for an unpinned dial, got %+v, %v", obs, err)
+	}
+}
+
+func TestObserve_Validation(t *testing.T) {
+	now := time.Now()
+	good := newCert(t, now.Add(90*24*time.Hour), "example.com")
+	goodLeaf, _ := x509.ParseCertificate(good.Certificate[0])
+	trusted := x509.NewCertPool()
+	trusted.AddCert(goodLeaf)
+
+	tests := []struct {
+		name      string
+		cert      tls.Certificate
+		host      string
+		roots     *x509.CertPool
+		want      string
+		nameMatch bool
+	}{
+		{"valid", good, "example.com", trusted, StatusValid, true},
+		{"name mismatch", good, "other.com", trusted, StatusNameMismatch, false},
+		{"self signed", good, "example.com", nil, StatusSelfSigned, true},
+		{"expired", newCert(t, now.Add(-time.Minute), "example.com"), "example.com", nil, StatusExpired, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dial := tlsServer(t, &tls.Config{Certificates: []tls.Certificate{tt.cert}})
+			cert, obs, err := Observe(context.Background(), tt.host, Options{Dial: dial, Roots: tt.roots})
+			if err != nil {
+				t.Fatalf("expected handshake to succeed despite the certificate, got %v", err)
+			}
+			if obs.Validation != tt.want {
+				t.Errorf("expected validation %s, got %s", tt.want, obs.Validation)
+			}
+			if obs.NameMatch != tt.nameMatch {
+				t.Errorf("expected name_match %v, got %v", tt.nameMatch, obs.NameMatch)
+			}
+			if cert == nil || !cert.SelfSigned {
+				t.Errorf("expected self-signed leaf, got %+v", cert)
+			}
+		})
+	}
+}
+
+func TestObserve_DaysToExpiry(t *testing.T) {
+	dial := tlsServer(t, &tls.Config{Certificates: []tls.Certificate{newCert(t, time.Now().Add(10*24*time.Hour+time.Hour), "example.com")}})
+	cert, _, err := Observe(context.Background(), "example.com", Options{Dial: dial})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert.DaysToExpiry != 10 {
+		t.Errorf("expected 10 days to expiry, got %d", cert.DaysToExpiry)
+	}
+}
+
+func TestFetchCert_SNI(t *testing.T) {
+	fallback := newCert(t, time.Now().Add(24*time.Hour), "default.test")
+	named := newCert(t, time.Now().Add(24*time.Hour), "example.com")
+	dial := tlsServer(t, &tls.Config{
+		Certificates: []tls.Certificate{fallback},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "example.com" {
+				return &named, nil
+			}
+			return nil, nil
+		},
+	})
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"host name", Options{Dial: dial}, "example.com"},
+		{"no sni", Options{Dial: dial, NoSNI: true}, "default.test"},
+		{"alternate sni", Options{Dial: dial, SNI: "other.test"}, "default.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := FetchCert(context.Background(), "example.com", tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cert.SubjectCN != tt.want {
+				t.Errorf("expected certificate for %s, got %s", tt.want, cert.SubjectCN)
+			}
+		})
+	}
+}
+
+func TestFetchCert_Cancelled(t *testing.T) {
+	dial := tlsServer(t, &tls.Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FetchCert(ctx, "example.com", Options{Dial: dial}); err == nil {
+		t.Error("expected a cancelled context to abort the fetch")
+	}
+}
+
+func TestFetchCert_Timeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// accepts but never answers the ClientHello
+	conns := make(chan net.Conn, 4)
+	t.Cleanup(func() {
+		ln.Close()
+		for {
+			select {
+			case c := <-conns:
+				c.Close()
+			default:
+				return
+			}
+		}
+	})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	start := time.Now()
+	if _, err := FetchCert(context.Background(), "127.0.0.1", Options{Port: port, Timeout: 200 * time.Millisecond}); err == nil {
+		t.Error("expected a silent server to time out")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("expected the fetch to give up after its timeout, took %s", d)
+	}
+}